@@ -0,0 +1,85 @@
+package gojs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+)
+
+// runOnLoop 在事件循环中执行 fn, fn 返回 Promise 时等待 Promise 完成
+// 结果确定后停止事件循环, 并取消未执行的 setTimeout 和 setInterval
+// ctx 超时或取消时中断执行, loop 为 nil 时直接执行 fn
+func runOnLoop(ctx context.Context, loop *eventloop.EventLoop, vm *goja.Runtime, fn func() (goja.Value, error)) (goja.Value, error) {
+	var output goja.Value
+	err := runWithContext(ctx, vm, loop, func() error {
+		if loop == nil {
+			var err error
+			output, err = fn()
+			return err
+		}
+		var err error
+		loop.Run(func(vm *goja.Runtime) {
+			output, err = fn()
+			if err != nil {
+				loop.StopNoWait()
+				return
+			}
+			promise, ok := asPromise(output)
+			if !ok || promise.State() != goja.PromiseStatePending {
+				loop.StopNoWait()
+				return
+			}
+			then, ok := goja.AssertFunction(output.(*goja.Object).Get("then"))
+			if !ok {
+				loop.StopNoWait()
+				return
+			}
+			settled := vm.ToValue(func(goja.FunctionCall) goja.Value {
+				loop.StopNoWait()
+				return goja.Undefined()
+			})
+			if _, err = then(output, settled, settled); err != nil {
+				loop.StopNoWait()
+			}
+		})
+		loop.Terminate()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	promise, ok := asPromise(output)
+	if !ok {
+		return output, nil
+	}
+	switch promise.State() {
+	case goja.PromiseStateFulfilled:
+		return promise.Result(), nil
+	case goja.PromiseStateRejected:
+		return nil, throwValue(vm, promise.Result())
+	default:
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("脚本返回的 Promise 未完成")
+	}
+}
+
+func asPromise(value goja.Value) (*goja.Promise, bool) {
+	if _, ok := value.(*goja.Object); !ok {
+		return nil, false
+	}
+	promise, ok := value.Export().(*goja.Promise)
+	return promise, ok
+}
+
+// throwValue 将 Promise 拒绝的值转换为 *goja.Exception
+func throwValue(vm *goja.Runtime, value goja.Value) error {
+	thrower, _ := goja.AssertFunction(vm.ToValue(func(goja.FunctionCall) goja.Value {
+		panic(value)
+	}))
+	_, err := thrower(goja.Undefined())
+	return err
+}
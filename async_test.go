@@ -0,0 +1,99 @@
+package gojs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestRun_Async(t *testing.T) {
+	js := `function sleep(ms) {
+	return new Promise(resolve => setTimeout(resolve, ms));
+}
+async function handler(j) {
+	await sleep(20);
+	return j + 1;
+}`
+	val, err := Run(js, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 2 {
+		t.Fatalf("expected 2, got %v", val)
+	}
+
+	val, err = Run(`async function handler() {
+	return 3;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 3 {
+		t.Fatalf("expected 3, got %v", val)
+	}
+}
+
+func TestRun_AsyncReject(t *testing.T) {
+	_, err := Run(`function handler() {
+	return new Promise((resolve, reject) => setTimeout(() => reject(new Error("bad frame")), 10));
+}`)
+	resErr := errors.UnWrapResponse(err)
+	if resErr == nil || resErr.Code != 100040005 {
+		t.Fatalf("expected error code 100040005, got %v", err)
+	}
+	t.Log(err)
+}
+
+func TestRun_AsyncTimeout(t *testing.T) {
+	js := `function handler(wait) {
+	if (!wait) {
+		return "ok";
+	}
+	return new Promise(resolve => {
+		setInterval(() => {}, 10);
+	});
+}`
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := RunByIdAndScriptWithContext(ctx, "async-timeout", js, true)
+	resErr := errors.UnWrapResponse(err)
+	if resErr == nil || resErr.Code != 100040015 {
+		t.Fatalf("expected error code 100040015, got %v", err)
+	}
+	val, err := RunById("async-timeout", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "ok" {
+		t.Fatalf("expected ok, got %v", val)
+	}
+
+	_, err = Run(`function handler() {
+	return new Promise(() => {});
+}`)
+	if err == nil {
+		t.Fatal("expected pending promise error")
+	}
+}
+
+func TestRun_TimerCancelled(t *testing.T) {
+	js := `function handler(schedule) {
+	if (schedule) {
+		setTimeout(() => { _state.fired = true; }, 10);
+	}
+	return _state.fired === true;
+}`
+	if _, err := RunByIdAndScript("timer", js, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	val, err := RunById("timer", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToBoolean() {
+		t.Fatal("expected pending timer to be cancelled after handler returned")
+	}
+}
@@ -0,0 +1,77 @@
+package gojs
+
+import (
+	"context"
+	"time"
+
+	"github.com/dop251/goja"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchResult 批量执行中一组参数的执行结果
+type BatchResult struct {
+	// 入口函数的返回值, 执行失败时为 nil
+	Value goja.Value
+	// 执行错误, 不影响其他参数的执行
+	Err error
+}
+
+// RunBatch 使用多组参数依次执行指定 id 脚本的入口函数, 脚本不存在或内容变化时加载脚本
+// 整批只查找一次缓存并获取一次 VM, 单组参数执行失败不影响其他参数, 错误记录在对应的 BatchResult 中
+// 加载脚本或获取 VM 失败时返回错误
+func (e *Engine) RunBatch(id, script string, args [][]interface{}) ([]BatchResult, error) {
+	return e.RunBatchWithContext(context.Background(), id, script, args)
+}
+
+// RunBatchWithContext 同 RunBatch, ctx 超时或取消时中断正在执行的参数, 之后的参数不再执行并返回超时错误
+func (e *Engine) RunBatchWithContext(ctx context.Context, id, script string, args [][]interface{}) ([]BatchResult, error) {
+	jsVM, err := e.getJsVm(ctx, id, script)
+	if err != nil {
+		return nil, err
+	}
+	return jsVM.batch(ctx, args)
+}
+
+// batch 在同一个 VM 中依次执行入口函数, 每组参数分别记录执行指标
+func (j *JSvm) batch(ctx context.Context, args [][]interface{}) (_ []BatchResult, err error) {
+	ctx, span := j.engine.tracer.Start(ctx, "gojs.batch", trace.WithAttributes(
+		attrScriptID.String(j.id),
+		attrScriptHash.String(j.Hash),
+		attrFunction.String(j.engine.o.EntryPoints[0]),
+		attrBatchSize.Int(len(args)),
+	))
+	defer func() { endSpan(span, err) }()
+	primary := false
+	for _, values := range args {
+		primary = primary || hasObject(values)
+	}
+	start := time.Now()
+	vm, release, err := j.acquire(ctx, primary)
+	if err != nil {
+		j.observeRun(start, err)
+		return nil, err
+	}
+	defer release()
+	results := make([]BatchResult, len(args))
+	failed := 0
+	for i, values := range args {
+		start := time.Now()
+		if ctx.Err() != nil {
+			results[i].Err = wrapRunErr(ctx, ctx.Err(), 100040005)
+		} else if err := j.engine.breakers.allow(j.id, j.Hash); err != nil {
+			// 熔断中的参数不执行, 不记录执行指标
+			results[i].Err = err
+			failed++
+			continue
+		} else {
+			results[i].Value, results[i].Err = j.apply(ctx, vm, "", values)
+			j.engine.breakers.record(j.id, j.Hash, results[i].Err)
+		}
+		j.observeRun(start, results[i].Err)
+		if results[i].Err != nil {
+			failed++
+		}
+	}
+	span.SetAttributes(attrBatchErrors.Int(failed))
+	return results, nil
+}
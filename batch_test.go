@@ -0,0 +1,77 @@
+package gojs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestEngine_RunBatch(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(hex) {
+	if (hex.length % 2 !== 0) {
+		throw new Error("bad frame " + hex);
+	}
+	return Buffer.from(hex, "hex").readUInt16BE(0);
+}`
+	args := [][]interface{}{{"0102"}, {"abc"}, {"00ff"}}
+	results, err := e.RunBatch("batch", js, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].Value.ToInteger() != 258 {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+	var scriptErr *ScriptError
+	if !errors.As(results[1].Err, &scriptErr) || scriptErr.Message != "bad frame abc" || results[1].Value != nil {
+		t.Fatalf("expected script error, got %+v", results[1])
+	}
+	if results[2].Err != nil || results[2].Value.ToInteger() != 255 {
+		t.Fatalf("unexpected result %+v", results[2])
+	}
+	stats := e.Stats()["batch"]
+	if stats.Invocations != 3 || stats.Errors[CategoryRuntime] != 1 || stats.CacheMisses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if _, err := e.RunBatch("batch-invalid", "function handler(", args); err == nil {
+		t.Fatal("expected compile error")
+	}
+}
+
+func TestEngine_RunBatchTimeout(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(loop) {
+	while (loop) {}
+	return 1;
+}`
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results, err := e.RunBatchWithContext(ctx, "batch-timeout", js, [][]interface{}{{false}, {true}, {false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	}
+	for _, result := range results[1:] {
+		if resErr := errors.UnWrapResponse(result.Err); resErr == nil || resErr.Code != 100040015 {
+			t.Fatalf("expected error code 100040015, got %v", result.Err)
+		}
+	}
+	val, err := e.RunById("batch-timeout", false)
+	if err != nil || val.ToInteger() != 1 {
+		t.Fatalf("expected vm to be usable after timeout, got %v %v", val, err)
+	}
+}
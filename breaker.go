@@ -0,0 +1,212 @@
+package gojs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+var CircuitOpenError = errors.New400Response(100040023, "脚本连续执行失败已熔断")
+
+// BreakerState 脚本熔断器的状态
+type BreakerState string
+
+const (
+	// BreakerClosed 正常执行
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen 已熔断, 执行直接返回 CircuitOpenError
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen 冷却时间已过, 允许一次试探执行, 成功后恢复, 失败后重新熔断
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker 按脚本 id 熔断的配置
+// 只有脚本抛出异常和执行超时计为失败, 等待 VM 超时, 函数不存在和返回值转换失败等不影响熔断
+// 脚本内容变化后熔断状态重置
+type CircuitBreaker struct {
+	// Failures 连续失败多少次后熔断, 小于等于 0 时为 5
+	Failures int
+	// Window 连续失败需要在该时间内发生, 第一次失败超过该时间后重新计数, 0 表示不限制
+	Window time.Duration
+	// CoolDown 熔断后经过多久允许一次试探执行, 小于等于 0 时为 30 秒
+	CoolDown time.Duration
+	// OnStateChange 熔断状态变化时的回调, 在执行脚本的协程中同步调用, 可以用于记录日志或上报指标
+	OnStateChange func(id string, from, to BreakerState)
+}
+
+// SetCircuitBreaker 设置脚本熔断, 脚本连续执行失败后一段时间内不再执行, 默认不熔断
+func SetCircuitBreaker(cb CircuitBreaker) Option {
+	return func(o *options) {
+		if cb.Failures <= 0 {
+			cb.Failures = 5
+		}
+		if cb.CoolDown <= 0 {
+			cb.CoolDown = 30 * time.Second
+		}
+		o.CircuitBreaker = &cb
+	}
+}
+
+// breaker 单个脚本 id 的熔断状态
+type breaker struct {
+	// hash 记录状态时的脚本内容, 脚本内容变化后重置
+	hash     string
+	state    BreakerState
+	failures int
+	// first 本轮连续失败中第一次失败的时间
+	first    time.Time
+	openedAt time.Time
+	// probing 半开状态下试探执行是否正在进行
+	probing bool
+}
+
+type stateChange struct {
+	from, to BreakerState
+}
+
+// breakers 按脚本 id 记录熔断状态, 只记录失败过的脚本
+type breakers struct {
+	cfg *CircuitBreaker
+
+	mu    sync.Mutex
+	items map[string]*breaker
+}
+
+func newBreakers(cfg *CircuitBreaker) *breakers {
+	return &breakers{cfg: cfg, items: make(map[string]*breaker)}
+}
+
+// get 返回 id 的熔断状态, 脚本内容变化时重置, 未记录时返回 nil, 调用时需持有锁
+func (b *breakers) get(id, hash string) *breaker {
+	br, ok := b.items[id]
+	if !ok {
+		return nil
+	}
+	if br.hash != hash {
+		delete(b.items, id)
+		return nil
+	}
+	return br
+}
+
+// allow 检查是否允许执行, 熔断中返回 CircuitOpenError, 冷却时间已过时转为半开并允许一次试探执行
+func (b *breakers) allow(id, hash string) error {
+	if b.cfg == nil {
+		return nil
+	}
+	var change *stateChange
+	err := func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		br := b.get(id, hash)
+		if br == nil {
+			return nil
+		}
+		switch br.state {
+		case BreakerOpen:
+			if time.Since(br.openedAt) < b.cfg.CoolDown {
+				return CircuitOpenError
+			}
+			change = &stateChange{from: BreakerOpen, to: BreakerHalfOpen}
+			br.state = BreakerHalfOpen
+			br.probing = true
+		case BreakerHalfOpen:
+			if br.probing {
+				return CircuitOpenError
+			}
+			br.probing = true
+		}
+		return nil
+	}()
+	b.notify(id, change)
+	return err
+}
+
+// record 记录一次执行的结果
+func (b *breakers) record(id, hash string, err error) {
+	if b.cfg == nil {
+		return
+	}
+	failed := false
+	if err != nil {
+		category := errorCategory(err)
+		if category != CategoryRuntime && category != CategoryTimeout {
+			b.release(id, hash)
+			return
+		}
+		failed = true
+	}
+	var change *stateChange
+	b.mu.Lock()
+	br := b.get(id, hash)
+	switch {
+	case failed && br == nil:
+		br = &breaker{hash: hash, state: BreakerClosed}
+		b.items[id] = br
+		change = br.fail(b.cfg, time.Now())
+	case failed:
+		change = br.fail(b.cfg, time.Now())
+	case br != nil:
+		// 执行成功后恢复
+		if br.state != BreakerClosed {
+			change = &stateChange{from: br.state, to: BreakerClosed}
+		}
+		delete(b.items, id)
+	}
+	b.mu.Unlock()
+	b.notify(id, change)
+}
+
+// fail 记录一次失败, 返回状态变化
+func (br *breaker) fail(cfg *CircuitBreaker, now time.Time) *stateChange {
+	switch br.state {
+	case BreakerHalfOpen:
+		br.state, br.openedAt, br.probing = BreakerOpen, now, false
+		return &stateChange{from: BreakerHalfOpen, to: BreakerOpen}
+	case BreakerOpen:
+		// 熔断前已开始的执行
+		return nil
+	}
+	if br.failures == 0 || cfg.Window > 0 && now.Sub(br.first) > cfg.Window {
+		br.failures, br.first = 0, now
+	}
+	br.failures++
+	if br.failures < cfg.Failures {
+		return nil
+	}
+	br.state, br.openedAt = BreakerOpen, now
+	return &stateChange{from: BreakerClosed, to: BreakerOpen}
+}
+
+// release 不计入熔断的执行结束, 半开状态下允许下一次试探执行
+func (b *breakers) release(id, hash string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if br := b.get(id, hash); br != nil {
+		br.probing = false
+	}
+}
+
+// state 返回 id 的熔断状态和连续失败次数
+func (b *breakers) state(id, hash string) (BreakerState, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if br := b.get(id, hash); br != nil {
+		return br.state, br.failures
+	}
+	return BreakerClosed, 0
+}
+
+// remove 删除 id 的熔断状态
+func (b *breakers) remove(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.items, id)
+}
+
+func (b *breakers) notify(id string, change *stateChange) {
+	if change != nil && b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(id, change.from, change.to)
+	}
+}
@@ -0,0 +1,143 @@
+package gojs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestEngine_CircuitBreaker(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	e, err := NewEngine(SetCircuitBreaker(CircuitBreaker{
+		Failures: 3,
+		CoolDown: 50 * time.Millisecond,
+		OnStateChange: func(id string, from, to BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, fmt.Sprintf("%s:%s->%s", id, from, to))
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(fail) {
+	if (fail) {
+		throw new Error("bad frame");
+	}
+	return 1;
+}`
+	run := func(fail bool) *errors.ResponseError {
+		_, err := e.RunByIdAndScript("s", js, fail)
+		return errors.UnWrapResponse(err)
+	}
+	for i := 0; i < 3; i++ {
+		if resErr := run(true); resErr == nil || resErr.Code != 100040005 {
+			t.Fatalf("expected runtime error, got %v", resErr)
+		}
+	}
+	if resErr := run(false); resErr == nil || resErr.Code != 100040023 {
+		t.Fatalf("expected circuit open, got %v", resErr)
+	}
+	if info := e.List()[0]; info.Breaker != BreakerOpen || info.Failures != 3 || info.Runs != 3 {
+		t.Fatalf("expected open breaker without running, got %+v", info)
+	}
+
+	// 试探执行失败后重新熔断
+	time.Sleep(60 * time.Millisecond)
+	if resErr := run(true); resErr == nil || resErr.Code != 100040005 {
+		t.Fatalf("expected probe to run, got %v", resErr)
+	}
+	if resErr := run(false); resErr == nil || resErr.Code != 100040023 {
+		t.Fatalf("expected circuit open after failed probe, got %v", resErr)
+	}
+
+	// 试探执行成功后恢复
+	time.Sleep(60 * time.Millisecond)
+	if resErr := run(false); resErr != nil {
+		t.Fatalf("expected probe to succeed, got %v", resErr)
+	}
+	if info := e.List()[0]; info.Breaker != BreakerClosed || info.Failures != 0 {
+		t.Fatalf("expected closed breaker, got %+v", info)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"s:closed->open",
+		"s:open->half-open",
+		"s:half-open->open",
+		"s:open->half-open",
+		"s:half-open->closed",
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, changes)
+	}
+}
+
+func TestEngine_CircuitBreakerWindow(t *testing.T) {
+	e, err := NewEngine(SetCircuitBreaker(CircuitBreaker{Failures: 2, Window: 20 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	throw new Error("bad frame");
+}`
+	if _, err := e.RunByIdAndScript("s", js); err == nil {
+		t.Fatal("expected error")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := e.RunByIdAndScript("s", js); err == nil {
+		t.Fatal("expected error")
+	}
+	if info := e.List()[0]; info.Breaker != BreakerClosed || info.Failures != 1 {
+		t.Fatalf("expected failures outside window to restart counting, got %+v", info)
+	}
+	results, err := e.RunBatch("s", js, [][]interface{}{nil, nil, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resErr := errors.UnWrapResponse(results[2].Err); resErr == nil || resErr.Code != 100040023 {
+		t.Fatalf("expected batch to stop running after circuit opens, got %v", results[2].Err)
+	}
+}
+
+func TestEngine_CircuitBreakerReset(t *testing.T) {
+	e, err := NewEngine(SetCircuitBreaker(CircuitBreaker{Failures: 2, CoolDown: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	throw new Error("bad frame");
+}`
+	if _, err := e.RunByIdAndScript("s", js); err == nil {
+		t.Fatal("expected error")
+	}
+	// 函数不存在既不计为失败也不重置计数
+	if _, err := e.RunFunction("s", "missing"); err == nil {
+		t.Fatal("expected function not found")
+	}
+	if info := e.List()[0]; info.Failures != 1 {
+		t.Fatalf("expected 1 failure, got %+v", info)
+	}
+	if _, err := e.RunById("s"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := e.RunById("s"); err != CircuitOpenError {
+		t.Fatalf("expected circuit open, got %v", err)
+	}
+	val, err := e.RunByIdAndScript("s", `function handler() {
+	return 2;
+}`)
+	if err != nil {
+		t.Fatalf("expected changed script to reset breaker, got %v", err)
+	}
+	if val.ToInteger() != 2 {
+		t.Fatalf("expected 2, got %s", val)
+	}
+}
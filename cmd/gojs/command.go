@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/air-iot/gojs"
+	"github.com/dop251/goja"
+)
+
+// argument 入口函数的一个参数
+type argument struct {
+	hex  string
+	json string
+}
+
+// arguments 按出现顺序保存 --arg-hex 和 --arg-json 的参数
+type arguments []argument
+
+type argFlag struct {
+	args  *arguments
+	isHex bool
+}
+
+func (f argFlag) String() string {
+	return ""
+}
+
+func (f argFlag) Set(s string) error {
+	if f.isHex {
+		*f.args = append(*f.args, argument{hex: s})
+	} else {
+		*f.args = append(*f.args, argument{json: s})
+	}
+	return nil
+}
+
+// value 将参数转换为脚本中的值, Buffer 在脚本的主 VM 中创建
+func (a argument) value(vm *goja.Runtime) (interface{}, error) {
+	if a.hex != "" {
+		bs, err := hex.DecodeString(strings.Join(strings.Fields(a.hex), ""))
+		if err != nil {
+			return nil, fmt.Errorf("--arg-hex %s err,%s", a.hex, err)
+		}
+		return gojs.BytesToBuffer(vm, bs)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(a.json), &v); err != nil {
+		return nil, fmt.Errorf("--arg-json %s err,%s", a.json, err)
+	}
+	return v, nil
+}
+
+// parseArgs 解析参数, 脚本路径可以出现在参数之前或之后
+func parseArgs(fs *flag.FlagSet, args []string) (string, error) {
+	var script string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return "", err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		if script != "" {
+			return "", fmt.Errorf("多余的参数 %s", fs.Arg(0))
+		}
+		script = fs.Arg(0)
+		args = fs.Args()[1:]
+	}
+	return script, nil
+}
+
+func readScript(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("缺少脚本文件")
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// runCommand 执行脚本, parse 为 true 时使用 Parser 解析返回值
+func runCommand(args []string, stdout io.Writer, parse bool) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var values arguments
+	fs.Var(argFlag{args: &values, isHex: true}, "arg-hex", "以 Buffer 传入的参数")
+	fs.Var(argFlag{args: &values}, "arg-json", "以 json 解析后传入的参数")
+	name := fs.String("func", "", "执行的函数, 默认为 handler")
+	timeout := fs.Duration("timeout", 0, "执行超时时间")
+	profile := fs.String("profile", "", "将脚本的 CPU 采样结果以 pprof 格式写入文件")
+	repeat := fs.Int("repeat", 1, "执行次数, 输出最后一次的返回值")
+	path, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	script, err := readScript(path)
+	if err != nil {
+		return err
+	}
+	e := gojs.DefaultEngine()
+	jsVM, err := e.GetJsVm(path, script)
+	if err != nil {
+		return scriptError(err)
+	}
+	vals := make([]interface{}, len(values))
+	for i, arg := range values {
+		if vals[i], err = arg.value(jsVM.VM); err != nil {
+			return err
+		}
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	var p *gojs.CPUProfile
+	if *profile != "" {
+		if p, err = e.StartCPUProfile(path); err != nil {
+			return err
+		}
+	}
+	var val goja.Value
+	for i := 0; i < *repeat || i == 0; i++ {
+		if val, err = e.RunFunctionWithContext(ctx, path, *name, vals...); err != nil {
+			break
+		}
+	}
+	if p != nil {
+		if profileErr := writeProfile(p, *profile); profileErr != nil && err == nil {
+			err = profileErr
+		}
+	}
+	if err != nil {
+		return scriptError(err)
+	}
+	var output interface{}
+	if parse {
+		results, err := gojs.NewParser().Parse(val)
+		if err != nil {
+			return err
+		}
+		output = results
+	} else {
+		output = export(val)
+	}
+	bs, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(bs))
+	return nil
+}
+
+// writeProfile 结束 CPU 采样并写入文件
+func writeProfile(p *gojs.CPUProfile, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		_ = p.Stop(io.Discard)
+		return err
+	}
+	if err := p.Stop(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// export 将返回值转换为 json 可以输出的值, Buffer 输出为十六进制字符串
+func export(val goja.Value) interface{} {
+	if gojs.IsBuffer(val) {
+		if bs, err := gojs.BufferToBytes(val); err == nil {
+			return hex.EncodeToString(bs)
+		}
+	}
+	if !gojs.IsValid(val) {
+		return nil
+	}
+	return val.Export()
+}
+
+// scriptError 在错误信息后附加脚本的调用栈
+func scriptError(err error) error {
+	var scriptErr *gojs.ScriptError
+	if !errors.As(err, &scriptErr) {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString(err.Error())
+	for _, frame := range scriptErr.Stack {
+		if frame.Line == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n    at %s (%d:%d)", frame.Function, frame.Line, frame.Column)
+	}
+	if len(scriptErr.Stack) == 0 && scriptErr.Line > 0 {
+		fmt.Fprintf(&sb, "\n    at %d:%d", scriptErr.Line, scriptErr.Column)
+	}
+	return errors.New(sb.String())
+}
+
+func validateCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	path, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	script, err := readScript(path)
+	if err != nil {
+		return err
+	}
+	result := gojs.Validate(script)
+	for _, issue := range result.Errors {
+		fmt.Fprintf(stdout, "%s:%d:%d: error: %s\n", path, issue.Line, issue.Column, issue.Message)
+	}
+	for _, issue := range result.Warnings {
+		fmt.Fprintf(stdout, "%s:%d:%d: warning: %s\n", path, issue.Line, issue.Column, issue.Message)
+	}
+	if !result.Valid() {
+		return fmt.Errorf("脚本检查未通过")
+	}
+	fmt.Fprintln(stdout, "ok")
+	return nil
+}
+
+// replCommand 逐行执行 js, 语句不完整时继续读取下一行
+func replCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	path, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	vm, err := gojs.GetVm()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		script, err := readScript(path)
+		if err != nil {
+			return err
+		}
+		if _, err := vm.RunScript(path, script); err != nil {
+			return scriptError(err)
+		}
+	}
+	scanner := bufio.NewScanner(stdin)
+	var input strings.Builder
+	prompt := "> "
+	for {
+		fmt.Fprint(stdout, prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(stdout)
+			return scanner.Err()
+		}
+		input.WriteString(scanner.Text())
+		input.WriteString("\n")
+		val, err := vm.RunString(input.String())
+		if err != nil && strings.Contains(err.Error(), "Unexpected end of input") {
+			prompt = "... "
+			continue
+		}
+		input.Reset()
+		prompt = "> "
+		if err != nil {
+			fmt.Fprintln(stdout, scriptError(err))
+			continue
+		}
+		if !gojs.IsValid(val) {
+			fmt.Fprintln(stdout, val)
+			continue
+		}
+		bs, err := json.Marshal(export(val))
+		if err != nil {
+			fmt.Fprintln(stdout, val.String())
+			continue
+		}
+		fmt.Fprintln(stdout, string(bs))
+	}
+}
@@ -0,0 +1,61 @@
+// gojs 在本地执行和调试数据处理脚本
+//
+//	gojs run script.js --arg-json '"topic"' --arg-hex 0103...   执行入口函数并输出返回值
+//	gojs parse script.js --arg-hex 0103...                      执行入口函数并输出 ParseResult
+//	gojs run script.js --profile cpu.pprof --repeat 1000        执行 1000 次并写入 CPU 采样结果
+//	gojs validate script.js                                     检查脚本
+//	gojs repl [script.js]                                       交互式执行 js
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `gojs 在本地执行和调试数据处理脚本
+
+用法:
+  gojs run <script.js> [flags]       执行入口函数并以 json 输出返回值
+  gojs parse <script.js> [flags]     执行入口函数并以 json 输出 ParseResult
+  gojs validate <script.js>          检查语法, 入口函数和未定义的全局变量
+  gojs repl [script.js]              交互式执行 js, 可以先加载脚本
+
+run 和 parse 的参数:
+  --arg-hex <hex>     以 Buffer 传入的参数, 可以重复, 按出现顺序传入
+  --arg-json <json>   以 json 解析后传入的参数, 可以重复, 按出现顺序传入
+  --func <name>       执行的函数, 默认为 handler
+  --timeout <d>       执行超时时间, 如 5s, 默认不超时
+  --profile <file>    将脚本的 CPU 采样结果以 pprof 格式写入文件, 使用 go tool pprof 查看
+  --repeat <n>        执行次数, 默认为 1, 输出最后一次的返回值, 与 --profile 一起使用
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("缺少子命令")
+	}
+	switch args[0] {
+	case "run":
+		return runCommand(args[1:], stdout, false)
+	case "parse":
+		return runCommand(args[1:], stdout, true)
+	case "validate":
+		return validateCommand(args[1:], stdout)
+	case "repl":
+		return replCommand(args[1:], stdin, stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("未知的子命令 %s", args[0])
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "script.js")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun_Commands(t *testing.T) {
+	path := writeScript(t, `function handler(topic, message) {
+	return [{id: topic, values: {t: message.readInt16BE(0) / 10, n: _.max([1, 2])}}];
+}
+function raw(message) {
+	return message;
+}`)
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"run", path, "--arg-json", `"dev1"`, "--arg-hex", "00 fa"}, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), `"t": 25`) {
+		t.Fatalf("unexpected output %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"parse", "--arg-json", `"dev1"`, "--arg-hex", "00fa", path}, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), `"id": "dev1"`) {
+		t.Fatalf("unexpected output %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"run", path, "--func", "raw", "--arg-hex", "0102"}, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(stdout.String()) != `"0102"` {
+		t.Fatalf("unexpected output %s", stdout.String())
+	}
+
+	stdout.Reset()
+	pprof := filepath.Join(t.TempDir(), "cpu.pprof")
+	if err := run([]string{"run", path, "--func", "raw", "--arg-hex", "0102", "--profile", pprof, "--repeat", "10"}, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(pprof); err != nil || info.Size() == 0 {
+		t.Fatalf("expected profile written, got %v", err)
+	}
+
+	stdout.Reset()
+	if err := run([]string{"validate", path}, nil, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	stdin := strings.NewReader("var a = {\n x: 1 }\nraw(a.x + 1)\n")
+	if err := run([]string{"repl", path}, stdin, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "> 2\n") {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+
+	if err := run([]string{"unknown"}, nil, &stdout, &stderr); err == nil {
+		t.Fatal("expected unknown command error")
+	}
+}
@@ -0,0 +1,59 @@
+package gojs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestRunByIdAndScriptWithContext(t *testing.T) {
+	js := `function handler(loop) {
+	while (loop) {}
+	return 1;
+}`
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := RunByIdAndScriptWithContext(ctx, "timeout", js, true)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	resErr := errors.UnWrapResponse(err)
+	if resErr == nil || resErr.Code != 100040015 {
+		t.Fatalf("expected error code 100040015, got %v", err)
+	}
+
+	val, err := RunByIdWithContext(context.Background(), "timeout", false)
+	if err != nil {
+		t.Fatalf("cached vm should be usable after interrupt, %v", err)
+	}
+	if val.ToInteger() != 1 {
+		t.Fatalf("expected 1, got %v", val)
+	}
+}
+
+func TestRunWithContext_Cancel(t *testing.T) {
+	js := `function handler() {
+	while (true) {}
+}`
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err := RunWithContext(ctx, js)
+	resErr := errors.UnWrapResponse(err)
+	if resErr == nil || resErr.Code != 100040015 {
+		t.Fatalf("expected error code 100040015, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	_, err = RunWithContext(context.Background(), `function handler() { throw new Error("x") }`)
+	resErr = errors.UnWrapResponse(err)
+	if resErr == nil || resErr.Code != 100040005 {
+		t.Fatalf("expected error code 100040005, got %v", err)
+	}
+}
@@ -0,0 +1,509 @@
+package gojs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/dop251/goja"
+)
+
+// ConversionError 脚本返回值转换为 Go 类型失败
+// 通过 errors.As 从 ExportTo 和 RunInto 返回的错误中获取
+type ConversionError struct {
+	// 转换失败的值在返回值中的路径, 如 $.data[1].value, $ 为返回值本身
+	Path string `json:"path"`
+	// 目标类型
+	Type string `json:"type"`
+	// js 中的值
+	Value interface{} `json:"value,omitempty"`
+	// 失败原因
+	Reason string `json:"reason"`
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("%s: 不能将 %s 转换为 %s, %s", e.Path, describeValue(e.Value), e.Type, e.Reason)
+}
+
+// describeValue 返回错误信息中 js 值的描述
+func describeValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(val)
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// RunInto 使用默认 Engine 执行脚本的入口函数, 并将返回值按 json tag 转换为 T, 见 EngineRunInto
+func RunInto[T any](id, script string, values ...interface{}) (T, error) {
+	return EngineRunIntoWithContext[T](context.Background(), defaultEngine, id, script, values...)
+}
+
+// RunIntoWithContext 同 RunInto, ctx 超时或取消时中断脚本执行
+func RunIntoWithContext[T any](ctx context.Context, id, script string, values ...interface{}) (T, error) {
+	return EngineRunIntoWithContext[T](ctx, defaultEngine, id, script, values...)
+}
+
+// EngineRunInto 使用 e 执行脚本的入口函数, 并将返回值按 json tag 转换为 T, 见 ExportTo
+// Go 的方法不能有类型参数, 因此以函数的形式提供
+func EngineRunInto[T any](e *Engine, id, script string, values ...interface{}) (T, error) {
+	return EngineRunIntoWithContext[T](context.Background(), e, id, script, values...)
+}
+
+// EngineRunIntoWithContext 同 EngineRunInto, ctx 超时或取消时中断脚本执行
+func EngineRunIntoWithContext[T any](ctx context.Context, e *Engine, id, script string, values ...interface{}) (T, error) {
+	var result T
+	jsVM, err := e.getJsVm(ctx, id, script)
+	if err != nil {
+		return result, err
+	}
+	// 返回值可能有 getter, 需要在归还 VM 前转换, 转换失败不计入执行指标和熔断
+	var exportErr error
+	if err := jsVM.callLocked(ctx, "", values, func(output goja.Value) {
+		exportErr = ExportTo(output, &result)
+	}); err != nil {
+		return result, err
+	}
+	return result, exportErr
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	bytesType       = reflect.TypeOf([]byte(nil))
+	arrayBufferType = reflect.TypeOf(goja.ArrayBuffer{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// ExportTo 将脚本返回值按 json tag 转换到 target 指向的值, target 必须为非 nil 指针
+// Buffer, TypedArray 和 ArrayBuffer 可以转换为 []byte, js 数字转换为整数时不能有小数部分且不能溢出
+// Date 和毫秒时间戳可以转换为 time.Time, undefined 和 null 保留零值, js 对象中多余的属性忽略
+// 失败时返回包含 *ConversionError 的错误, 错误码为 100040019
+func ExportTo(val goja.Value, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+	if err := exportValue(val, rv.Elem(), "$"); err != nil {
+		scriptErr := &ScriptError{Category: CategoryConversion, Message: err.Error(), Err: err}
+		return errors.Wrap400Response(scriptErr, 100040019, "脚本返回值转换失败")
+	}
+	return nil
+}
+
+func isNullish(val goja.Value) bool {
+	return val == nil || goja.IsUndefined(val) || goja.IsNull(val)
+}
+
+func conversionError(val goja.Value, rv reflect.Value, path, reason string) *ConversionError {
+	err := &ConversionError{Path: path, Type: rv.Type().String(), Reason: reason}
+	if !isNullish(val) {
+		err.Value = val.Export()
+	}
+	return err
+}
+
+func exportValue(val goja.Value, rv reflect.Value, path string) error {
+	if isNullish(val) {
+		rv.SetZero()
+		return nil
+	}
+	if rv.Type() == timeType {
+		return exportTime(val, rv, path)
+	}
+	if rv.Kind() != reflect.Pointer && rv.Kind() != reflect.Interface && rv.CanAddr() && rv.Addr().Type().Implements(unmarshalerType) {
+		bs, err := json.Marshal(exportAny(val))
+		if err == nil {
+			err = rv.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(bs)
+		}
+		if err != nil {
+			return conversionError(val, rv, path, err.Error())
+		}
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return exportValue(val, rv.Elem(), path)
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return conversionError(val, rv, path, "不支持非空接口")
+		}
+		if v := exportAny(val); v != nil {
+			rv.Set(reflect.ValueOf(v))
+		}
+		return nil
+	case reflect.String:
+		s, ok := val.Export().(string)
+		if !ok {
+			return conversionError(val, rv, path, "不是字符串")
+		}
+		rv.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, ok := val.Export().(bool)
+		if !ok {
+			return conversionError(val, rv, path, "不是布尔值")
+		}
+		rv.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return exportInt(val, rv, path)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return exportUint(val, rv, path)
+	case reflect.Float32, reflect.Float64:
+		f, ok := number(val)
+		if !ok {
+			return conversionError(val, rv, path, "不是数字")
+		}
+		if rv.OverflowFloat(f) {
+			return conversionError(val, rv, path, "超出范围")
+		}
+		rv.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if bs, ok := bytesOf(val); ok {
+				rv.SetBytes(append([]byte(nil), bs...))
+				return nil
+			}
+		}
+		return exportList(val, rv, path)
+	case reflect.Array:
+		return exportList(val, rv, path)
+	case reflect.Map:
+		return exportMap(val, rv, path)
+	case reflect.Struct:
+		return exportStruct(val, rv, path)
+	default:
+		return conversionError(val, rv, path, "不支持的类型")
+	}
+}
+
+// number 返回 js 数字的值
+func number(val goja.Value) (float64, bool) {
+	switch v := val.Export().(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func exportInt(val goja.Value, rv reflect.Value, path string) error {
+	var i int64
+	switch v := val.Export().(type) {
+	case int64:
+		i = v
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return conversionError(val, rv, path, "不是整数")
+		}
+		if v < math.MinInt64 || v >= math.MaxInt64 {
+			return conversionError(val, rv, path, "超出范围")
+		}
+		i = int64(v)
+	default:
+		return conversionError(val, rv, path, "不是数字")
+	}
+	if rv.OverflowInt(i) {
+		return conversionError(val, rv, path, "超出范围")
+	}
+	rv.SetInt(i)
+	return nil
+}
+
+func exportUint(val goja.Value, rv reflect.Value, path string) error {
+	var u uint64
+	switch v := val.Export().(type) {
+	case int64:
+		if v < 0 {
+			return conversionError(val, rv, path, "不能为负数")
+		}
+		u = uint64(v)
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return conversionError(val, rv, path, "不是整数")
+		}
+		if v < 0 {
+			return conversionError(val, rv, path, "不能为负数")
+		}
+		if v >= math.MaxUint64 {
+			return conversionError(val, rv, path, "超出范围")
+		}
+		u = uint64(v)
+	default:
+		return conversionError(val, rv, path, "不是数字")
+	}
+	if rv.OverflowUint(u) {
+		return conversionError(val, rv, path, "超出范围")
+	}
+	rv.SetUint(u)
+	return nil
+}
+
+// exportTime Date 对象转换为时间, 数字作为毫秒时间戳, 字符串按 RFC3339 解析
+func exportTime(val goja.Value, rv reflect.Value, path string) error {
+	switch v := val.Export().(type) {
+	case time.Time:
+		rv.Set(reflect.ValueOf(v))
+	case int64:
+		rv.Set(reflect.ValueOf(time.UnixMilli(v)))
+	case float64:
+		rv.Set(reflect.ValueOf(time.UnixMilli(int64(v))))
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return conversionError(val, rv, path, "不是 RFC3339 格式的时间")
+		}
+		rv.Set(reflect.ValueOf(t))
+	default:
+		return conversionError(val, rv, path, "不是 Date, 时间戳或时间字符串")
+	}
+	return nil
+}
+
+// bytesOf 返回 Buffer, Uint8Array 或 ArrayBuffer 的字节
+func bytesOf(val goja.Value) ([]byte, bool) {
+	if t := val.ExportType(); t != bytesType && t != arrayBufferType {
+		return nil, false
+	}
+	switch v := val.Export().(type) {
+	case []byte:
+		return v, true
+	case goja.ArrayBuffer:
+		return v.Bytes(), true
+	default:
+		return nil, false
+	}
+}
+
+// isArray 判断是否为 js 数组或 TypedArray
+func isArray(obj *goja.Object) bool {
+	if obj.ClassName() == "Array" {
+		return true
+	}
+	t := obj.ExportType()
+	return t != nil && t.Kind() == reflect.Slice
+}
+
+func exportList(val goja.Value, rv reflect.Value, path string) error {
+	obj, ok := val.(*goja.Object)
+	if !ok || !isArray(obj) {
+		return conversionError(val, rv, path, "不是数组")
+	}
+	length := int(obj.Get("length").ToInteger())
+	if rv.Kind() == reflect.Slice {
+		rv.Set(reflect.MakeSlice(rv.Type(), length, length))
+	} else if length > rv.Len() {
+		length = rv.Len()
+	}
+	for i := 0; i < length; i++ {
+		if err := exportValue(obj.Get(strconv.Itoa(i)), rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i := length; i < rv.Len(); i++ {
+		rv.Index(i).SetZero()
+	}
+	return nil
+}
+
+// isObject 判断是否为普通的 js 对象
+func isObject(val goja.Value) (*goja.Object, bool) {
+	obj, ok := val.(*goja.Object)
+	if !ok || obj.ClassName() != "Object" {
+		return nil, false
+	}
+	if t := obj.ExportType(); t == nil || t.Kind() != reflect.Map {
+		return nil, false
+	}
+	return obj, true
+}
+
+func exportMap(val goja.Value, rv reflect.Value, path string) error {
+	obj, ok := isObject(val)
+	if !ok {
+		return conversionError(val, rv, path, "不是对象")
+	}
+	keyType := rv.Type().Key()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+	for _, key := range obj.Keys() {
+		k := reflect.New(keyType).Elem()
+		keyPath := propertyPath(path, key)
+		switch keyType.Kind() {
+		case reflect.String:
+			k.SetString(key)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(key, 10, 64)
+			if err != nil || k.OverflowInt(i) {
+				return &ConversionError{Path: keyPath, Type: keyType.String(), Value: key, Reason: "属性名不是有效的整数"}
+			}
+			k.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u, err := strconv.ParseUint(key, 10, 64)
+			if err != nil || k.OverflowUint(u) {
+				return &ConversionError{Path: keyPath, Type: keyType.String(), Value: key, Reason: "属性名不是有效的整数"}
+			}
+			k.SetUint(u)
+		default:
+			return conversionError(val, rv, path, "不支持的 map key 类型")
+		}
+		elem := reflect.New(rv.Type().Elem()).Elem()
+		if err := exportValue(obj.Get(key), elem, keyPath); err != nil {
+			return err
+		}
+		rv.SetMapIndex(k, elem)
+	}
+	return nil
+}
+
+// propertyPath 返回属性的路径, 属性名不是标识符时使用 ["key"]
+func propertyPath(path, key string) string {
+	for i, c := range key {
+		if !(c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+		}
+	}
+	if key == "" {
+		return path + `[""]`
+	}
+	return path + "." + key
+}
+
+func exportStruct(val goja.Value, rv reflect.Value, path string) error {
+	obj, ok := isObject(val)
+	if !ok {
+		return conversionError(val, rv, path, "不是对象")
+	}
+	var keys []string
+	for _, field := range structFields(rv.Type(), nil) {
+		name := field.name
+		prop := obj.Get(name)
+		if prop == nil {
+			// 与 encoding/json 一致, 找不到属性时忽略大小写匹配
+			if keys == nil {
+				keys = obj.Keys()
+			}
+			for _, key := range keys {
+				if strings.EqualFold(key, name) {
+					name, prop = key, obj.Get(key)
+					break
+				}
+			}
+		}
+		if prop == nil {
+			continue
+		}
+		fv, err := fieldByIndex(rv, field.index)
+		if err != nil {
+			return conversionError(val, rv, path, err.Error())
+		}
+		if err := exportValue(prop, fv, propertyPath(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields 返回结构体的字段和 json 中的名称, 没有 tag 的匿名结构体字段展开到上层
+func structFields(t reflect.Type, index []int) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int(nil), index...), i)
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, structFields(ft, fieldIndex)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{name: name, index: fieldIndex})
+	}
+	return fields
+}
+
+// fieldByIndex 同 reflect.Value.FieldByIndex, 匿名指针字段为 nil 时创建
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, fmt.Errorf("不能设置未导出的匿名字段 %s", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
+
+// exportAny 将 js 值转换为 interface{}, Buffer 和 ArrayBuffer 转换为 []byte, 对象和数组递归转换
+func exportAny(val goja.Value) interface{} {
+	if isNullish(val) {
+		return nil
+	}
+	if bs, ok := bytesOf(val); ok {
+		return append([]byte(nil), bs...)
+	}
+	obj, ok := val.(*goja.Object)
+	if !ok {
+		return val.Export()
+	}
+	if obj.ClassName() == "Array" {
+		list := make([]interface{}, obj.Get("length").ToInteger())
+		for i := range list {
+			list[i] = exportAny(obj.Get(strconv.Itoa(i)))
+		}
+		return list
+	}
+	if _, ok := isObject(obj); ok {
+		keys := obj.Keys()
+		m := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			m[key] = exportAny(obj.Get(key))
+		}
+		return m
+	}
+	return obj.Export()
+}
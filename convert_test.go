@@ -0,0 +1,158 @@
+package gojs
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+type message struct {
+	MessageType  int               `json:"messageType"`
+	MessageType1 string            `json:"messageType1"`
+	Data         []byte            `json:"data"`
+	Raw          []byte            `json:"raw"`
+	Count        uint16            `json:"count"`
+	Ratio        float64           `json:"ratio"`
+	Time         time.Time         `json:"time"`
+	Points       []point           `json:"points"`
+	Tags         map[string]string `json:"tags"`
+	Extra        interface{}       `json:"extra"`
+	Optional     *point            `json:"optional"`
+	Ignored      string            `json:"-"`
+}
+
+type point struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+func TestRunInto(t *testing.T) {
+	js := `function handler() {
+	return {
+		messageType: 1,
+		messageType1: "a",
+		data: new Uint8Array(Buffer.from('{"type":"query"}')).buffer,
+		raw: Buffer.from("00fa01", "hex").slice(1),
+		count: 65535,
+		ratio: 0.5,
+		time: new Date(1700000000000),
+		points: [{name: "t", value: 2.0}, {name: "h", value: 1e12}],
+		tags: {unit: "C"},
+		extra: {buf: Buffer.from([1, 2]), list: [1, "a"]},
+		Ignored: "x"
+	};
+}`
+	got, err := RunInto[message]("run-into", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MessageType != 1 || got.MessageType1 != "a" || string(got.Data) != `{"type":"query"}` {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if !bytes.Equal(got.Raw, []byte{0xfa, 0x01}) || got.Count != 65535 || got.Ratio != 0.5 {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if got.Time.UnixMilli() != 1700000000000 || got.Optional != nil || got.Ignored != "" {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if len(got.Points) != 2 || got.Points[0].Value != 2 || got.Points[1].Value != 1e12 || got.Tags["unit"] != "C" {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	extra := got.Extra.(map[string]interface{})
+	if !bytes.Equal(extra["buf"].([]byte), []byte{1, 2}) || extra["list"].([]interface{})[1] != "a" {
+		t.Fatalf("unexpected extra %+v", extra)
+	}
+}
+
+func TestEngineRunInto(t *testing.T) {
+	e, err := NewEngine(SetGlobal("tenant", "e1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EngineRunInto[point](e, "engine-run-into", `function handler(v) {
+	return {name: tenant, value: v};
+}`, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (point{Name: "e1", Value: 3}) {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if _, err := e.RunById("engine-run-into", 1); err != nil {
+		t.Fatalf("expected script cached in e, got %v", err)
+	}
+}
+
+func TestEngineRunInto_Concurrent(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// getter 在转换时执行, 需要持有 VM 的锁
+	js := `let count = 0;
+function handler(v) {
+	return {
+		name: "p",
+		get value() {
+			count++;
+			return v;
+		}
+	};
+}`
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				got, err := EngineRunInto[point](e, "getter", js, i)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got.Value != int64(i) {
+					t.Errorf("expected %d, got %+v", i, got)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRunInto_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		js   string
+		path string
+	}{
+		{"fraction", `return {points: [{value: 1}, {value: 1.5}]}`, "$.points[1].value"},
+		{"overflow", `return {count: 65536}`, "$.count"},
+		{"negative", `return {count: -1}`, "$.count"},
+		{"type", `return {tags: {"unit name": 1}}`, `$.tags["unit name"]`},
+		{"not array", `return {points: {}}`, "$.points"},
+		{"not object", `return [1]`, "$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RunInto[message]("run-into-"+tt.name, "function handler() {\n"+tt.js+"\n}")
+			var convErr *ConversionError
+			if !errors.As(err, &convErr) {
+				t.Fatalf("expected ConversionError, got %v", err)
+			}
+			if convErr.Path != tt.path {
+				t.Fatalf("expected path %s, got %s", tt.path, convErr)
+			}
+			if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040019 {
+				t.Fatalf("expected error code 100040019, got %v", err)
+			}
+			var scriptErr *ScriptError
+			if !errors.As(err, &scriptErr) || scriptErr.Category != CategoryConversion {
+				t.Fatalf("expected conversion category, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package gojs
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Clock 脚本中 Date.now(), new Date() 和 moment() 使用的时间来源
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc 使用函数作为 Clock
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// FixedClock 返回始终为 t 的 Clock
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+// ManualClock 手动控制的 Clock, 只有调用 Set 或 Advance 时才变化
+type ManualClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{now: t}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Set 设置当前时间
+func (c *ManualClock) Set(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = t
+}
+
+// Advance 将当前时间增加 d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Deterministic 确定性执行使用的时间和随机数来源, 使相同输入的执行结果可以复现
+type Deterministic struct {
+	// Clock 时间来源, 为 nil 时使用系统时间
+	Clock Clock
+	// Seed 随机数种子, 每次执行前以该种子重置 Math.random 和 uuid 的随机数序列
+	Seed int64
+}
+
+// SetDeterministic 设置 Engine 所有 VM 的时间和随机数来源, 单次执行可以通过 NewDeterministicContext 覆盖
+func SetDeterministic(d Deterministic) Option {
+	return func(o *options) {
+		o.Deterministic = &d
+	}
+}
+
+type deterministicKey struct{}
+
+// NewDeterministicContext 返回使用 d 作为时间和随机数来源执行脚本的 ctx, 优先于 SetDeterministic
+// 加载脚本时的顶层代码同样生效
+func NewDeterministicContext(ctx context.Context, d Deterministic) context.Context {
+	return context.WithValue(ctx, deterministicKey{}, &d)
+}
+
+// FromDeterministicContext 获取 ctx 中的 Deterministic
+func FromDeterministicContext(ctx context.Context) (Deterministic, bool) {
+	d, ok := ctx.Value(deterministicKey{}).(*Deterministic)
+	if !ok {
+		return Deterministic{}, false
+	}
+	return *d, true
+}
+
+// sourceSymbol VM 全局对象上保存 runtimeSource 的属性, 脚本中无法访问
+var sourceSymbol = goja.NewSymbol("gojs.source")
+
+// runtimeSource 单个 VM 的时间和随机数来源, 只在执行 VM 的协程中使用
+type runtimeSource struct {
+	// base Engine 设置的 Deterministic, 为 nil 时使用系统时间和随机数
+	base  *Deterministic
+	clock Clock
+	rand  *rand.Rand
+}
+
+// enableSource 设置 VM 的时间和随机数来源以及 uuid 对象
+func enableSource(vm *goja.Runtime, base *Deterministic) error {
+	s := &runtimeSource{base: base}
+	s.reset(nil)
+	if err := vm.GlobalObject().DefineDataPropertySymbol(sourceSymbol, vm.ToValue(s), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE); err != nil {
+		return err
+	}
+	vm.SetTimeSource(s.now)
+	vm.SetRandSource(s.float64)
+	uuid := vm.NewObject()
+	if err := uuid.Set("v4", s.uuidV4); err != nil {
+		return err
+	}
+	return vm.Set("uuid", uuid)
+}
+
+// sourceOf 获取 VM 的 runtimeSource
+func sourceOf(vm *goja.Runtime) *runtimeSource {
+	s, _ := vm.GlobalObject().GetSymbol(sourceSymbol).Export().(*runtimeSource)
+	return s
+}
+
+// withContext 按 ctx 中的 Deterministic 重置时间和随机数来源, ctx 中没有时使用 Engine 的设置
+func (s *runtimeSource) withContext(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	d, _ := ctx.Value(deterministicKey{}).(*Deterministic)
+	s.reset(d)
+}
+
+func (s *runtimeSource) reset(d *Deterministic) {
+	if d == nil {
+		d = s.base
+	}
+	if d == nil {
+		s.clock, s.rand = nil, nil
+		return
+	}
+	s.clock = d.Clock
+	s.rand = rand.New(rand.NewSource(d.Seed))
+}
+
+func (s *runtimeSource) now() time.Time {
+	if s.clock != nil {
+		return s.clock.Now()
+	}
+	return time.Now()
+}
+
+func (s *runtimeSource) float64() float64 {
+	if s.rand != nil {
+		return s.rand.Float64()
+	}
+	return rand.Float64()
+}
+
+// uuidV4 生成随机 uuid, 设置了 Deterministic 时使用种子生成
+func (s *runtimeSource) uuidV4() string {
+	var b [16]byte
+	if s.rand != nil {
+		_, _ = s.rand.Read(b[:])
+	} else {
+		_, _ = crand.Read(b[:])
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,72 @@
+package gojs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEngine_Deterministic(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewManualClock(now)
+	e, err := NewEngine(SetDeterministic(Deterministic{Clock: clock, Seed: 42}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `var loaded = Date.now();
+function handler() {
+	return [loaded, Date.now(), new Date().toISOString(), moment().valueOf(), Math.random(), uuid.v4()];
+}`
+	run := func(ctx context.Context) []interface{} {
+		t.Helper()
+		val, err := e.RunByIdAndScriptWithContext(ctx, "deterministic", js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return val.Export().([]interface{})
+	}
+	first := run(context.Background())
+	if first[0] != now.UnixMilli() || first[1] != now.UnixMilli() || first[3] != now.UnixMilli() {
+		t.Fatalf("expected fixed time, got %v", first)
+	}
+	if first[2] != "2024-01-02T03:04:05.000Z" {
+		t.Fatalf("unexpected date %v", first[2])
+	}
+	second := run(context.Background())
+	if first[4] != second[4] || first[5] != second[5] {
+		t.Fatalf("expected random sequence to restart from seed, got %v and %v", first, second)
+	}
+	if uuid := first[5].(string); len(uuid) != 36 || uuid[14] != '4' {
+		t.Fatalf("unexpected uuid %s", uuid)
+	}
+
+	clock.Advance(time.Second)
+	if got := run(context.Background()); got[1] != now.Add(time.Second).UnixMilli() {
+		t.Fatalf("expected advanced clock, got %v", got[1])
+	}
+
+	ctx := NewDeterministicContext(context.Background(), Deterministic{Clock: FixedClock(time.UnixMilli(1000)), Seed: 7})
+	got := run(ctx)
+	if got[1] != int64(1000) || got[4] == first[4] {
+		t.Fatalf("expected context to override engine settings, got %v", got)
+	}
+}
+
+func TestEngine_NonDeterministic(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	return [Date.now(), uuid.v4(), uuid.v4()];
+}`
+	before := time.Now().UnixMilli()
+	val, err := e.Run(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := val.Export().([]interface{})
+	if got[0].(int64) < before || got[1] == got[2] {
+		t.Fatalf("expected system time and random uuid, got %v", got)
+	}
+}
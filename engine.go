@@ -0,0 +1,695 @@
+package gojs
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/air-iot/gojs/api"
+	log2 "github.com/air-iot/gojs/log"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja_nodejs/eventloop"
+	"github.com/dop251/goja_nodejs/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultPackages 默认加载的 js 库, 内置库在第一次访问时才执行
+var defaultPackages = []string{
+	"packages/bcd.js",
+	"packages/buffer.js",
+	"packages/lodash.js",
+	"packages/crypto-js.js",
+	"packages/moment.js",
+	"packages/xml-js.js",
+	"packages/formulajs.js",
+	"packages/iconv-lite.js",
+	"packages/forge.js",
+	"packages/pako.min.js",
+	//"packages/uuid.js",
+}
+
+// compiledPackages 已编译的内置 js 库, goja.Program 可以在多个 VM 间共享
+var compiledPackages sync.Map
+
+type options struct {
+	Expiration        time.Duration
+	CleanupInterval   time.Duration
+	BackgroundCleanup bool
+	Packages          []string
+	Programs          []*goja.Program
+	Logger            *log2.Log
+	Globals           map[string]interface{}
+	PoolSize          int
+	PoolIdleTimeout   time.Duration
+	PoolMaxWait       time.Duration
+	EntryPoints       []string
+	StateStore        StateStore
+	MetricsSink       MetricsSink
+	TracerProvider    trace.TracerProvider
+	Deterministic     *Deterministic
+	MaxEntries        int
+	MaxMemory         int64
+	EvictionHandler   EvictionHandler
+	CircuitBreaker    *CircuitBreaker
+}
+
+// Option 定义配置项
+type Option func(*options)
+
+// SetCacheExpiration 设置脚本缓存的过期时间和清理间隔, 默认 5 分钟未使用过期, 每 10 分钟清理
+// expiration 小于等于 0 时不过期, 单个脚本的过期时间可以通过 Engine.SetScriptTTL 设置
+// 默认不使用后台协程, 过期的脚本在访问时或每隔 cleanupInterval 在加载脚本时清理, 见 SetBackgroundCleanup
+// cleanupInterval 小于等于 0 时过期的脚本只在访问时清理
+func SetCacheExpiration(expiration, cleanupInterval time.Duration) Option {
+	return func(o *options) {
+		o.Expiration = expiration
+		o.CleanupInterval = cleanupInterval
+	}
+}
+
+// SetCacheMaxEntries 设置最多缓存的脚本数量, 超出时移出最久未使用的脚本 VM, 默认不限制
+// 编译后的脚本缓存同样受该数量限制
+func SetCacheMaxEntries(n int) Option {
+	return func(o *options) {
+		o.MaxEntries = n
+	}
+}
+
+// SetCacheMaxMemory 设置缓存的脚本 VM 占用内存的上限, 超出时移出最久未使用的脚本 VM, 默认不限制
+// 内存按 VM 池大小和脚本长度估算, 不包括脚本运行时创建的数据
+func SetCacheMaxMemory(bytes int64) Option {
+	return func(o *options) {
+		o.MaxMemory = bytes
+	}
+}
+
+// SetEvictionHandler 设置脚本 VM 过期, 被淘汰或被替换时的回调, 可以用于持久化 _state 或上报指标
+func SetEvictionHandler(h EvictionHandler) Option {
+	return func(o *options) {
+		o.EvictionHandler = h
+	}
+}
+
+// SetPackages 设置加载的内置 js 库, 如 packages/lodash.js
+func SetPackages(packagePaths ...string) Option {
+	return func(o *options) {
+		o.Packages = packagePaths
+	}
+}
+
+// AddProgram 添加预加载的自定义 js 程序, 在内置库之后执行
+func AddProgram(programs ...*goja.Program) Option {
+	return func(o *options) {
+		o.Programs = append(o.Programs, programs...)
+	}
+}
+
+// SetBackgroundCleanup 设置是否启动后台协程, 每隔 SetCacheExpiration 的 cleanupInterval 清理过期的脚本, 默认不启动
+// 长时间没有加载脚本时过期的 VM 也能及时释放并回调 EvictionHandler, 启动后需要调用 Engine.Close 结束该协程
+func SetBackgroundCleanup(enabled bool) Option {
+	return func(o *options) {
+		o.BackgroundCleanup = enabled
+	}
+}
+
+// SetLogger 设置脚本中 logger 对象使用的日志
+func SetLogger(l *log2.Log) Option {
+	return func(o *options) {
+		o.Logger = l
+	}
+}
+
+// SetGlobal 设置 VM 的全局对象, 会覆盖同名的内置对象
+func SetGlobal(key string, value interface{}) Option {
+	return func(o *options) {
+		if o.Globals == nil {
+			o.Globals = make(map[string]interface{})
+		}
+		o.Globals[key] = value
+	}
+}
+
+// SetPoolSize 设置每个脚本的 VM 池大小, 即同一脚本可以并发执行的数量, 默认为 1
+// 池中每个 VM 拥有独立的 _state, 设置 SetStateStore 或通过 NewStateContext 指定 key 时, 使用同一 key 的执行仍然串行
+func SetPoolSize(size int) Option {
+	return func(o *options) {
+		o.PoolSize = size
+	}
+}
+
+// SetPoolIdleTimeout 设置 VM 池中空闲 VM 的回收时间, 主 VM 不会被回收, 默认不回收
+func SetPoolIdleTimeout(idleTimeout time.Duration) Option {
+	return func(o *options) {
+		o.PoolIdleTimeout = idleTimeout
+	}
+}
+
+// SetPoolMaxWait 设置等待 VM 池中空闲 VM 的最长时间, 超时返回 PoolWaitError, 默认一直等待
+func SetPoolMaxWait(maxWait time.Duration) Option {
+	return func(o *options) {
+		o.PoolMaxWait = maxWait
+	}
+}
+
+// SetEntryPoints 设置脚本必须定义的函数, 加载脚本时检查, 默认为 handler
+// 第一个函数作为 Run, RunById 和 RunByIdAndScript 执行的入口函数
+func SetEntryPoints(names ...string) Option {
+	return func(o *options) {
+		o.EntryPoints = names
+	}
+}
+
+// SetStateStore 设置脚本 _state 的存储, 设置后以脚本 id 或 NewStateContext 指定的 key 加载和保存 _state, 同一 key 的执行串行进行
+// 默认不设置, 此时只有通过 NewStateContext 指定 key 的执行使用内存中的 _state, 其他执行使用 VM 自身的 _state, VM 过期后丢失
+// 以脚本 id 为 key 保存的 _state 在 VM 过期或被淘汰后保留, Engine.Invalidate 时删除
+func SetStateStore(store StateStore) Option {
+	return func(o *options) {
+		o.StateStore = store
+	}
+}
+
+// SetMetricsSink 设置执行指标的接收者, 不设置时只能通过 Engine.Stats 获取指标
+func SetMetricsSink(sink MetricsSink) Option {
+	return func(o *options) {
+		o.MetricsSink = sink
+	}
+}
+
+// SetTracerProvider 设置链路追踪使用的 TracerProvider, 默认为 otel.GetTracerProvider()
+// 带 ctx 的执行函数会为脚本编译, VM 创建和函数执行创建 span
+func SetTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.TracerProvider = tp
+	}
+}
+
+// Engine 脚本执行引擎
+// 每个 Engine 拥有独立的脚本缓存, js 库和日志, 同一进程中的多个 Engine 互不影响
+type Engine struct {
+	o        options
+	cache    *lruCache[*JSvm]
+	registry *require.Registry
+	// libraries 延迟加载的内置库
+	libraries []*library
+	// programs 创建 VM 时执行的 js 程序
+	programs []*goja.Program
+	// scripts 编译后的用户脚本, 以脚本内容的 hash 为 key
+	scripts *lruCache[*compiledScript]
+	apilib  *api.Lib
+	// stateLocks 保证使用同一 _state 的脚本串行执行
+	stateLocks keyLocks
+	// states 未设置 SetStateStore 时, 通过 NewStateContext 指定 key 的 _state 的存储
+	states *MemoryStateStore
+	// loadLocks 保证同一 id 的脚本串行加载
+	loadLocks keyLocks
+	// breakers 按脚本 id 的熔断状态
+	breakers *breakers
+	// closed Close 后为 true, 不再加载和执行缓存的脚本
+	closed atomic.Bool
+	// stop Close 时关闭, 结束清理过期数据的协程
+	stop chan struct{}
+	// runs 所有 VM 池中正在进行的执行, 包括已被替换或移出缓存的 VM, Close 时等待结束
+	runs runGroup
+	// globals VM 中的全局变量名, 用于 Validate 检查未定义的变量
+	globalsOnce sync.Once
+	globals     map[string]bool
+	globalsErr  error
+	// readonlyGlobals VM 中不可赋值的全局变量名, 与 globals 一起计算, SetObj 不能设置
+	readonlyGlobals map[string]bool
+	// metrics 按脚本 id 记录的执行指标
+	metrics *metrics
+	tracer  trace.Tracer
+}
+
+// NewEngine 创建脚本执行引擎
+// 设置 SetBackgroundCleanup 时启动清理过期脚本的协程, 此时不再使用的 Engine 必须调用 Close 结束该协程
+func NewEngine(opts ...Option) (*Engine, error) {
+	o := options{
+		Expiration:      5 * time.Minute,
+		CleanupInterval: 10 * time.Minute,
+		Packages:        defaultPackages,
+		PoolSize:        1,
+		EntryPoints:     []string{"handler"},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.EntryPoints) == 0 {
+		return nil, fmt.Errorf("entry points is empty")
+	}
+	if o.Logger == nil {
+		o.Logger = log2.NewLogger()
+	}
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+	libs := make([]*library, 0, len(o.Packages))
+	programs := make([]*goja.Program, 0, len(o.Programs))
+	for _, packagePath := range o.Packages {
+		if lib, ok := libraries[packagePath]; ok {
+			if _, err := fs.Stat(F, packagePath); err != nil {
+				return nil, fmt.Errorf("read %s err,%s", packagePath, err)
+			}
+			libs = append(libs, lib)
+			continue
+		}
+		p, err := compilePackage(packagePath)
+		if err != nil {
+			return nil, err
+		}
+		programs = append(programs, p)
+	}
+	programs = append(programs, o.Programs...)
+	registry := require.NewRegistry()
+	registerLibraryModules(registry, libs)
+	vms := newLRUCache[*JSvm](o.Expiration, o.CleanupInterval, o.MaxEntries, o.MaxMemory)
+	vms.size = func(jsVM *JSvm) int64 {
+		return jsVM.memoryEstimate()
+	}
+	e := &Engine{
+		o:         o,
+		cache:     vms,
+		registry:  registry,
+		libraries: libs,
+		programs:  programs,
+		scripts:   newLRUCache[*compiledScript](o.Expiration, o.CleanupInterval, o.MaxEntries, 0),
+		apilib:    api.NewLib(),
+		metrics:   newMetrics(o.MetricsSink),
+		tracer:    o.TracerProvider.Tracer(instrumentationName),
+		breakers:  newBreakers(o.CircuitBreaker),
+		states:    NewMemoryStateStore(),
+		stop:      make(chan struct{}),
+	}
+	vms.onEvict = e.evicted
+	if o.BackgroundCleanup && o.CleanupInterval > 0 {
+		go e.janitor(o.CleanupInterval)
+	}
+	return e, nil
+}
+
+// janitor 每隔 interval 清理过期的脚本 VM 和编译结果, Close 时结束
+func (e *Engine) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.cache.cleanup()
+			e.scripts.cleanup()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// evicted 脚本 VM 移出缓存后清理该 id 的数据, 并回调 EvictionHandler
+// 脚本内容变化替换 VM 和关闭引擎时保留, 以便继续统计和关闭后读取
+func (e *Engine) evicted(id string, jsVM *JSvm, reason EvictReason) {
+	if reason != EvictReplaced && reason != EvictClosed {
+		e.metrics.remove(id)
+		e.breakers.remove(id)
+	}
+	if e.o.EvictionHandler != nil {
+		e.o.EvictionHandler(id, jsVM, reason)
+	}
+}
+
+// SetScriptTTL 设置指定 id 脚本 VM 的过期时间, 覆盖 SetCacheExpiration, ttl 小于 0 时不过期, 等于 0 时恢复默认
+// 对已缓存和之后加载的脚本都生效
+func (e *Engine) SetScriptTTL(id string, ttl time.Duration) {
+	e.cache.setPolicy(id, func(p *cachePolicy) {
+		p.ttl = ttl
+	})
+}
+
+// PinScript 固定指定 id 的脚本 VM, 固定后不会过期, 也不会因超出缓存容量被淘汰
+// 对已缓存和之后加载的脚本都生效
+func (e *Engine) PinScript(id string) {
+	e.cache.setPolicy(id, func(p *cachePolicy) {
+		p.pinned = true
+	})
+}
+
+// UnpinScript 取消固定指定 id 的脚本 VM
+func (e *Engine) UnpinScript(id string) {
+	e.cache.setPolicy(id, func(p *cachePolicy) {
+		p.pinned = false
+	})
+}
+
+// scriptHash 计算脚本内容的 md5
+func scriptHash(script string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(script)))
+}
+
+func compilePackage(packagePath string) (*goja.Program, error) {
+	if p, ok := compiledPackages.Load(packagePath); ok {
+		return p.(*goja.Program), nil
+	}
+	packageBytes, err := F.ReadFile(packagePath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s err,%s", packagePath, err)
+	}
+	p, err := goja.Compile(packagePath, string(packageBytes), false)
+	if err != nil {
+		return nil, fmt.Errorf("compile %s err,%s", packagePath, err)
+	}
+	actual, _ := compiledPackages.LoadOrStore(packagePath, p)
+	return actual.(*goja.Program), nil
+}
+
+// GetVm 创建加载了 js 库和内置对象的 VM
+func (e *Engine) GetVm() (*goja.Runtime, error) {
+	vm := goja.New()
+	e.registry.Enable(vm)
+	if err := e.initVm(vm, newLoggerRef(e.o.Logger)); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// newLoopVm 创建运行在事件循环上的 VM, 脚本中可以使用 setTimeout, setInterval 和 Promise
+// logger 为脚本中 console 和 logger 使用的日志
+func (e *Engine) newLoopVm(logger *loggerRef) (*eventloop.EventLoop, *goja.Runtime, error) {
+	loop := eventloop.NewEventLoop(eventloop.WithRegistry(e.registry), eventloop.EnableConsole(false))
+	var (
+		vm  *goja.Runtime
+		err error
+	)
+	loop.Run(func(r *goja.Runtime) {
+		vm = r
+		err = e.initVm(r, logger)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return loop, vm, nil
+}
+
+// initVm 加载 js 库和内置对象
+func (e *Engine) initVm(vm *goja.Runtime, logger *loggerRef) error {
+	if err := enableOutput(vm, logger); err != nil {
+		return errors.Wrap400Err(err, 100040001)
+	}
+	if err := enableSource(vm, e.o.Deterministic); err != nil {
+		return errors.Wrap400Err(err, 100040001)
+	}
+	obj := vm.GlobalObject()
+	state := map[string]interface{}{}
+	if err := obj.Set("_state", state); err != nil {
+		return errors.Wrap400Err(err, 100040001)
+	}
+	if err := enableLibraries(vm, e.libraries); err != nil {
+		return errors.Wrap400Err(err, 100040002)
+	}
+	for _, program := range e.programs {
+		_, err := vm.RunProgram(program)
+		if err != nil {
+			return errors.Wrap400Err(err, 100040002)
+		}
+	}
+
+	_ = vm.Set("apilib", e.apilib)
+	_ = AttachCrc(vm)
+	for key, value := range e.o.Globals {
+		if err := vm.Set(key, value); err != nil {
+			return errors.Wrap400Err(err, 100040001)
+		}
+	}
+	return nil
+}
+
+// GetVmCallback 创建 VM, 并通过回调函数对 VM 进行自定义设置
+func (e *Engine) GetVmCallback(cb Callback) (*goja.Runtime, error) {
+	vm, err := e.GetVm()
+	if err != nil {
+		return nil, err
+	}
+	_ = AttachCrc(vm)
+	if cb != nil {
+		if err := cb(vm); err != nil {
+			return nil, err
+		}
+	}
+	return vm, nil
+}
+
+// NewJsVm 创建或获取缓存的脚本 VM, logOpts 见 GetJsVm
+func (e *Engine) NewJsVm(id, script string, logOpts ...log2.Option) (*JSvm, error) {
+	jsVM, err := e.GetJsVm(id, script, logOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return jsVM, nil
+}
+
+// GetJsVm 获取缓存的脚本 VM, 不存在时创建, 脚本内容变化时重新加载脚本
+// logOpts 在 Engine 日志的基础上设置该脚本 console 和 logger 的日志上下文, 如项目, 表和模块
+// 不指定时使用该脚本之前设置的日志上下文, 从未设置时使用 Engine 的日志
+func (e *Engine) GetJsVm(id, script string, logOpts ...log2.Option) (*JSvm, error) {
+	return e.getJsVm(context.Background(), id, script, logOpts...)
+}
+
+func (e *Engine) getJsVm(ctx context.Context, id, script string, logOpts ...log2.Option) (*JSvm, error) {
+	if e.closed.Load() {
+		return nil, EngineClosedError
+	}
+	hash := scriptHash(script)
+	jsVM, _ := e.cache.get(id)
+	if jsVM == nil || jsVM.Hash != hash {
+		// 未命中在 reloadJsVm 中开始记录该 id 的指标后记录
+		return e.reloadJsVm(ctx, id, hash, script, logOpts...)
+	}
+	e.metrics.observeCache(id, true)
+	if len(logOpts) > 0 {
+		jsVM.logger.Store(e.o.Logger.With(logOpts...))
+	}
+	return jsVM, nil
+}
+
+// peekJsVm 获取缓存的脚本 VM, 不刷新过期时间和使用顺序, 未找到时返回 nil
+func (e *Engine) peekJsVm(id string) *JSvm {
+	jsVM, _ := e.cache.peek(id)
+	return jsVM
+}
+
+// reloadJsVm 在新的 VM 中加载脚本, 加载成功后替换缓存中的 VM
+// 脚本内容变化时创建新的 VM, 避免旧脚本的全局变量和函数残留, 旧 VM 上正在进行的执行不受影响, 结束后释放
+// 同一 id 的加载串行进行, 并发加载相同脚本时只加载一次
+func (e *Engine) reloadJsVm(ctx context.Context, id, hash, script string, logOpts ...log2.Option) (_ *JSvm, err error) {
+	unlock := e.loadLocks.lock(id)
+	defer unlock()
+	if e.closed.Load() {
+		e.metrics.observeCache(id, false)
+		return nil, EngineClosedError
+	}
+	// 加载期间记录该 id 的指标, 加载失败且没有缓存的 VM 时删除
+	e.metrics.track(id)
+	e.metrics.observeCache(id, false)
+	defer func() {
+		if err != nil && e.peekJsVm(id) == nil {
+			e.metrics.remove(id)
+		}
+	}()
+	old := e.peekJsVm(id)
+	if old != nil && old.Hash == hash {
+		if len(logOpts) > 0 {
+			old.logger.Store(e.o.Logger.With(logOpts...))
+		}
+		return old, nil
+	}
+	compiled, err := e.compileScript(ctx, hash, script)
+	if err != nil {
+		return nil, err
+	}
+	// 重新加载脚本时沿用之前的日志上下文和 SetObj 设置的对象
+	logger := newLoggerRef(e.o.Logger)
+	switch {
+	case len(logOpts) > 0:
+		logger.Store(e.o.Logger.With(logOpts...))
+	case old != nil:
+		logger = old.logger
+	}
+	objects := new(vmObjects)
+	if old != nil {
+		objects = old.objects
+	}
+	newVm := func(ctx context.Context) (*JSvm, error) {
+		return e.loadJsVm(ctx, id, compiled, hash, script, logger, objects)
+	}
+	jsVM, err := newVm(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jsVM.pool = newVmPool(e.o, &e.runs, jsVM, newVm)
+	jsVM.usedAt.Store(time.Now().UnixNano())
+	e.cache.set(id, jsVM, func(old *JSvm) bool {
+		return old != jsVM
+	})
+	// Close 不等待正在进行的加载, 在加载期间关闭时由这里移出, 保证关闭后缓存为空
+	if e.closed.Load() {
+		if _, ok := e.cache.remove(id, EvictClosed); ok {
+			jsVM.pool.stop()
+		}
+		return nil, EngineClosedError
+	}
+	// 加载期间旧的 VM 过期时指标已被删除
+	e.metrics.track(id)
+	return jsVM, nil
+}
+
+// scriptFileName 编译用户脚本时使用的文件名, 为脚本内容的 md5 加 .js
+// goja 的 CPU 采样只能通过文件名区分脚本, 因此用户脚本不再使用空文件名
+// 错误信息和调用栈中的位置同样使用该文件名, 如 at handler (<md5>.js:2:8(3)), 而不是 <eval> 或 (anonymous)
+func scriptFileName(hash string) string {
+	return hash + ".js"
+}
+
+// compiledScript 编译后的用户脚本
+type compiledScript struct {
+	program *goja.Program
+	// constants 脚本顶层的 const 变量名, SetObj 不能设置
+	constants map[string]bool
+}
+
+// compileScript 编译脚本, 编译结果以脚本内容的 hash 缓存
+func (e *Engine) compileScript(ctx context.Context, hash, script string) (c *compiledScript, err error) {
+	if c, ok := e.scripts.get(hash); ok {
+		return c, nil
+	}
+	_, span := e.tracer.Start(ctx, "gojs.compile", trace.WithAttributes(
+		attrScriptHash.String(hash),
+		attrScriptSize.Int(len(script)),
+	))
+	defer func() { endSpan(span, err) }()
+	prg, err := parser.ParseFile(nil, scriptFileName(hash), script, 0)
+	if err != nil {
+		return nil, errors.Wrap400Err(newScriptError(CategoryCompile, err), 100040003)
+	}
+	p, err := goja.CompileAST(prg, false)
+	if err != nil {
+		return nil, errors.Wrap400Err(newScriptError(CategoryCompile, err), 100040003)
+	}
+	c = &compiledScript{program: p, constants: topLevelConstants(prg)}
+	e.scripts.set(hash, c, nil)
+	return c, nil
+}
+
+// loadJsVm 创建 VM 并加载编译后的脚本
+func (e *Engine) loadJsVm(ctx context.Context, id string, compiled *compiledScript, hash, script string, logger *loggerRef, objects *vmObjects) (_ *JSvm, err error) {
+	ctx, span := e.tracer.Start(ctx, "gojs.vm.create", trace.WithAttributes(
+		attrScriptID.String(id),
+		attrScriptHash.String(hash),
+	))
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	loop, vm, err := e.newLoopVm(logger)
+	if err != nil {
+		return nil, err
+	}
+	sourceOf(vm).withContext(ctx)
+	// 加载脚本时顶层代码的日志携带 ctx, 但不记录到 LogCapture
+	if err := outputOf(vm).withContext(ctx, nil, func() error {
+		_, err := runOnLoop(ctx, loop, vm, func() (goja.Value, error) {
+			return vm.RunProgram(compiled.program)
+		})
+		return err
+	}); err != nil {
+		return nil, wrapRunErr(ctx, err, 100040003)
+	}
+	jsVM := &JSvm{VM: vm, objects: objects, logger: logger}
+	objects.apply(jsVM)
+	functions := make(map[string]goja.Callable, len(e.o.EntryPoints))
+	for _, name := range e.o.EntryPoints {
+		fn, ok := goja.AssertFunction(vm.Get(name))
+		if !ok {
+			return nil, functionNotFound(name)
+		}
+		functions[name] = fn
+	}
+	e.metrics.observeVmCreate(id, start)
+	jsVM.Handler = functions[e.o.EntryPoints[0]]
+	jsVM.Functions = functions
+	jsVM.Script = script
+	jsVM.Hash = hash
+	jsVM.constants = compiled.constants
+	jsVM.id = id
+	jsVM.engine = e
+	jsVM.loop = loop
+	jsVM.output = outputOf(vm)
+	jsVM.source = sourceOf(vm)
+	return jsVM, nil
+}
+
+// Run 执行脚本的入口函数, 默认为 handler, 以脚本内容的 md5 作为缓存 id
+func (e *Engine) Run(script string, values ...interface{}) (goja.Value, error) {
+	return e.RunWithContext(context.Background(), script, values...)
+}
+
+// RunWithContext 同 Run, ctx 超时或取消时中断脚本执行
+func (e *Engine) RunWithContext(ctx context.Context, script string, values ...interface{}) (goja.Value, error) {
+	id := scriptHash(script)
+	return e.RunByIdAndScriptWithContext(ctx, id, script, values...)
+}
+
+// RunByIdAndScript 执行指定 id 脚本的入口函数, 脚本不存在或内容变化时加载脚本
+func (e *Engine) RunByIdAndScript(id, script string, values ...interface{}) (goja.Value, error) {
+	return e.RunByIdAndScriptWithContext(context.Background(), id, script, values...)
+}
+
+// RunByIdAndScriptWithContext 同 RunByIdAndScript, ctx 超时或取消时中断脚本执行
+func (e *Engine) RunByIdAndScriptWithContext(ctx context.Context, id, script string, values ...interface{}) (goja.Value, error) {
+	jsVM, err := e.getJsVm(ctx, id, script)
+	if err != nil {
+		return nil, err
+	}
+	return jsVM.call(ctx, "", values...)
+}
+
+// RunById 执行已缓存的指定 id 脚本的入口函数
+func (e *Engine) RunById(id string, values ...interface{}) (goja.Value, error) {
+	return e.RunByIdWithContext(context.Background(), id, values...)
+}
+
+// RunByIdWithContext 同 RunById, ctx 超时或取消时中断脚本执行
+func (e *Engine) RunByIdWithContext(ctx context.Context, id string, values ...interface{}) (goja.Value, error) {
+	return e.RunFunctionWithContext(ctx, id, "", values...)
+}
+
+// RunFunction 执行已缓存的指定 id 脚本中名称为 name 的函数
+func (e *Engine) RunFunction(id, name string, values ...interface{}) (goja.Value, error) {
+	return e.RunFunctionWithContext(context.Background(), id, name, values...)
+}
+
+// RunFunctionWithContext 同 RunFunction, ctx 超时或取消时中断脚本执行
+// name 为空时执行入口函数
+func (e *Engine) RunFunctionWithContext(ctx context.Context, id, name string, values ...interface{}) (goja.Value, error) {
+	jsVM, err := e.cachedJsVm(id)
+	if err != nil {
+		return nil, err
+	}
+	return jsVM.call(ctx, name, values...)
+}
+
+// cachedJsVm 获取已缓存的指定 id 的脚本 VM 并刷新过期时间, 未找到时返回错误码 100040006
+func (e *Engine) cachedJsVm(id string) (*JSvm, error) {
+	if e.closed.Load() {
+		return nil, EngineClosedError
+	}
+	jsVM, ok := e.cache.get(id)
+	e.metrics.observeCache(id, ok)
+	if !ok {
+		err := errors.New400Response(100040006, "未找到vm")
+		e.metrics.observeRun(id, time.Now(), err)
+		return nil, err
+	}
+	return jsVM, nil
+}
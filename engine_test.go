@@ -0,0 +1,347 @@
+package gojs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/air-iot/gojs/log"
+)
+
+func TestEngine_Isolation(t *testing.T) {
+	e1, err := NewEngine(SetGlobal("tenant", "e1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := NewEngine(SetGlobal("tenant", "e2"), SetCacheExpiration(time.Minute, 2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	return tenant;
+}`
+	val1, err := e1.RunByIdAndScript("same", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val2, err := e2.RunByIdAndScript("same", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val1.String() != "e1" || val2.String() != "e2" {
+		t.Fatalf("expected e1 and e2, got %s and %s", val1, val2)
+	}
+
+	if _, err := e2.RunById("only-e1"); err == nil {
+		t.Fatal("expected vm not found")
+	}
+	if _, err := e1.RunByIdAndScript("only-e1", js); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e2.RunById("only-e1"); err == nil {
+		t.Fatal("expected engines not to share script cache")
+	}
+}
+
+func TestEngine_Packages(t *testing.T) {
+	e, err := NewEngine(SetPackages("packages/lodash.js"), SetLogger(log.NewLogger(log.SetModule("测试"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := e.Run(`function handler() {
+	return [typeof _, typeof moment, typeof Buffer];
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := val.Export().([]interface{})
+	if got[0] != "function" || got[1] != "undefined" || got[2] != "undefined" {
+		t.Fatalf("unexpected globals %v", got)
+	}
+
+	if _, err := NewEngine(SetPackages("packages/not-exist.js")); err == nil {
+		t.Fatal("expected error for missing package")
+	}
+}
+
+func TestEngine_ScriptChange(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js1 := `function helper() {
+	return 1;
+}
+function handler() {
+	return helper();
+}`
+	js2 := `function handler() {
+	return typeof helper;
+}`
+	old, err := e.GetJsVm("change", js1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := e.RunByIdAndScript("change", js2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "undefined" {
+		t.Fatalf("expected helper from old script to be gone, got %s", val)
+	}
+	jsVM, err := e.GetJsVm("change", js2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsVM == old || jsVM.VM == old.VM {
+		t.Fatal("expected a fresh vm after script change")
+	}
+
+	p1, err := e.compileScript(context.Background(), scriptHash(js1), js1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := e.compileScript(context.Background(), scriptHash(js1), js1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1 != p2 {
+		t.Fatal("expected compiled program to be cached")
+	}
+}
+
+func TestEngine_RunFunction(t *testing.T) {
+	e, err := NewEngine(SetEntryPoints("decode", "encode"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function decode(hex) {
+	return Buffer.from(hex, "hex").readUInt16BE(0);
+}
+function encode(value) {
+	const buf = Buffer.alloc(2);
+	buf.writeUInt16BE(value, 0);
+	return buf.toString("hex");
+}
+function validate(value) {
+	return value >= 0 && value <= 0xffff;
+}`
+	val, err := e.RunByIdAndScript("codec", js, "0102")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 258 {
+		t.Fatalf("expected 258, got %v", val)
+	}
+	val, err = e.RunFunction("codec", "encode", 258)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "0102" {
+		t.Fatalf("expected 0102, got %v", val)
+	}
+	val, err = e.RunFunction("codec", "validate", 70000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToBoolean() {
+		t.Fatal("expected validate to fail")
+	}
+	_, err = e.RunFunction("codec", "missing")
+	if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040004 {
+		t.Fatalf("expected error code 100040004, got %v", err)
+	}
+
+	_, err = e.RunByIdAndScript("decode-only", `function decode() {}`)
+	if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040004 {
+		t.Fatalf("expected missing encode error, got %v", err)
+	}
+}
+
+func TestEngine_ConcurrentReload(t *testing.T) {
+	e, err := NewEngine(SetPoolSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := []string{
+		`var version = "a";
+function handler() {
+	return version + ":" + tenant;
+}`,
+		`var version = "b";
+function handler() {
+	return version + ":" + tenant;
+}`,
+	}
+	jsVM, err := e.GetJsVm("reload", scripts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jsVM.SetObj("tenant", "t0"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	done := make(chan struct{})
+	setter := make(chan struct{})
+	go func() {
+		defer close(setter)
+		for n := 0; ; n++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if jsVM := e.peekJsVm("reload"); jsVM != nil {
+				if err := jsVM.SetObj("tenant", fmt.Sprintf("t%d", n%10)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				script := scripts[(i+n)%2]
+				want := string(script[15])
+				val, err := e.RunByIdAndScript("reload", script)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if got := val.String(); !strings.HasPrefix(got, want+":t") {
+					errs <- fmt.Errorf("expected version %s, got %s", want, got)
+					return
+				}
+				if _, err := e.RunById("reload"); err != nil {
+					errs <- err
+					return
+				}
+				if n%10 == 0 {
+					jsVM, err := e.GetJsVm("reload", script)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if err := jsVM.SetObj("tenant", fmt.Sprintf("t%d", i)); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	<-setter
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	jsVM, err = e.GetJsVm("reload", scripts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jsVM.SetObj("tenant", "last"); err != nil {
+		t.Fatal(err)
+	}
+	val, err := e.RunByIdAndScript("reload", scripts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "b:last" {
+		t.Fatalf("expected objects to survive reload, got %s", val)
+	}
+}
+
+func TestJSvm_SetObjInCallback(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsVM, err := e.GetJsVm("callback", `function handler() {
+	setTenant("t1");
+	return typeof tenant === "undefined" ? "" : tenant;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jsVM.SetObj("setTenant", func(v string) {
+		if err := jsVM.SetObj("tenant", v); err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 执行中设置的对象在下一次执行前生效
+	for _, want := range []string{"", "t1"} {
+		val, err := e.RunByIdWithContext(ctx, "callback")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.String() != want {
+			t.Fatalf("expected %q, got %q", want, val)
+		}
+	}
+}
+
+func TestJSvm_SetObjReadonly(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `const mode = "a", {unit} = {unit: "kW"};
+function handler() {
+	return mode + ":" + unit;
+}`
+	jsVM, err := e.GetJsVm("const", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"mode", "unit", "NaN"} {
+		if err := jsVM.SetObj(key, "b"); err == nil {
+			t.Fatalf("expected error setting %s", key)
+		}
+	}
+	val, err := e.RunById("const")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "a:kW" {
+		t.Fatalf("expected a:kW, got %s", val)
+	}
+
+	// 重新加载后与 const 冲突的对象被丢弃, 不影响执行
+	jsVM, err = e.GetJsVm("reload", `var mode = "a";
+function handler() {
+	return mode;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jsVM.SetObj("mode", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := e.RunById("reload"); err != nil || val.String() != "b" {
+		t.Fatalf("expected b, got %v %v", val, err)
+	}
+	for i := 0; i < 2; i++ {
+		val, err := e.RunByIdAndScript("reload", js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.String() != "a:kW" {
+			t.Fatalf("expected a:kW, got %s", val)
+		}
+	}
+}
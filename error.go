@@ -0,0 +1,120 @@
+package gojs
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+)
+
+// ErrorCategory 脚本错误类别
+type ErrorCategory string
+
+const (
+	// CategoryCompile 脚本语法错误
+	CategoryCompile ErrorCategory = "compile"
+	// CategoryRuntime 脚本执行时抛出异常
+	CategoryRuntime ErrorCategory = "runtime"
+	// CategoryHandlerMissing 脚本中未定义入口函数
+	CategoryHandlerMissing ErrorCategory = "handler-missing"
+	// CategoryTimeout 脚本执行超时或被取消
+	CategoryTimeout ErrorCategory = "timeout"
+	// CategoryConversion 脚本返回值转换失败
+	CategoryConversion ErrorCategory = "conversion"
+)
+
+// StackFrame 脚本调用栈中的一帧
+type StackFrame struct {
+	// 函数名
+	Function string `json:"function"`
+	// 脚本名称, 用户脚本为脚本内容的 md5 加 .js, 与错误信息中的文件名一致, 可以通过 md5 对应到脚本内容
+	File string `json:"file"`
+	// 行号, 从 1 开始, 0 表示 Go 函数
+	Line int `json:"line"`
+	// 列号, 从 1 开始
+	Column int `json:"column"`
+}
+
+// ScriptError 脚本错误
+// 执行脚本返回的错误可以通过 errors.As 获取 *ScriptError
+type ScriptError struct {
+	// 错误类别
+	Category ErrorCategory `json:"category"`
+	// js 中的错误信息, 如 throw new Error("a") 中的 a
+	Message string `json:"message"`
+	// js 中抛出的值
+	Value interface{} `json:"value,omitempty"`
+	// 出错位置的行号, 从 1 开始, 0 表示未知
+	Line int `json:"line"`
+	// 出错位置的列号, 从 1 开始, 0 表示未知
+	Column int `json:"column"`
+	// 调用栈
+	Stack []StackFrame `json:"stack,omitempty"`
+	// 原始错误
+	Err error `json:"-"`
+}
+
+func (e *ScriptError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// newScriptError 从 goja 的错误中解析错误信息, 位置和调用栈
+func newScriptError(category ErrorCategory, err error) *ScriptError {
+	scriptErr := &ScriptError{Category: category, Message: err.Error(), Err: err}
+	switch e := err.(type) {
+	case *goja.Exception:
+		scriptErr.Value, scriptErr.Message = exceptionValue(e.Value())
+		scriptErr.setStack(e.Stack())
+	case *goja.InterruptedError:
+		scriptErr.Message = e.String()
+		scriptErr.Value = e.Value()
+		scriptErr.setStack(e.Stack())
+	case parser.ErrorList:
+		if len(e) > 0 {
+			scriptErr.Message = e[0].Message
+			scriptErr.Line = e[0].Position.Line
+			scriptErr.Column = e[0].Position.Column
+		}
+	case *parser.Error:
+		scriptErr.Message = e.Message
+		scriptErr.Line = e.Position.Line
+		scriptErr.Column = e.Position.Column
+	}
+	return scriptErr
+}
+
+// exceptionValue 返回 js 中抛出的值和错误信息
+func exceptionValue(value goja.Value) (interface{}, string) {
+	if value == nil {
+		return nil, ""
+	}
+	if obj, ok := value.(*goja.Object); ok {
+		if message := obj.Get("message"); message != nil && obj.Get("name") != nil {
+			return value.String(), message.String()
+		}
+	}
+	return value.Export(), value.String()
+}
+
+// setStack 设置调用栈, 出错位置为第一个有位置信息的帧
+func (e *ScriptError) setStack(frames []goja.StackFrame) {
+	e.Stack = make([]StackFrame, 0, len(frames))
+	for i := range frames {
+		position := frames[i].Position()
+		e.Stack = append(e.Stack, StackFrame{
+			Function: frames[i].FuncName(),
+			File:     position.Filename,
+			Line:     position.Line,
+			Column:   position.Column,
+		})
+		if e.Line == 0 && position.Line > 0 {
+			e.Line = position.Line
+			e.Column = position.Column
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gojs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestScriptError_Runtime(t *testing.T) {
+	js := `function parse(buf) {
+	if (buf.length < 4) {
+		throw new RangeError("frame too short");
+	}
+}
+function handler(data) {
+	return parse(Buffer.from(data, "hex"));
+}`
+	_, err := Run(js, "0102")
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("expected ScriptError, got %v", err)
+	}
+	if scriptErr.Category != CategoryRuntime || scriptErr.Message != "frame too short" {
+		t.Fatalf("unexpected script error %+v", scriptErr)
+	}
+	if scriptErr.Line != 3 || scriptErr.Column != 9 {
+		t.Fatalf("expected error at 3:9, got %d:%d", scriptErr.Line, scriptErr.Column)
+	}
+	if len(scriptErr.Stack) < 2 || scriptErr.Stack[0].Function != "parse" || scriptErr.Stack[1].Function != "handler" {
+		t.Fatalf("unexpected stack %+v", scriptErr.Stack)
+	}
+	if file := scriptFileName(scriptHash(js)); scriptErr.Stack[0].File != file || !strings.Contains(err.Error(), file+":3:9") {
+		t.Fatalf("expected position in %s, got %v", file, err)
+	}
+	if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040005 {
+		t.Fatalf("expected error code 100040005, got %v", err)
+	}
+
+	_, err = Run(`function handler() {
+	throw {code: 7};
+}`)
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("expected ScriptError, got %v", err)
+	}
+	value, ok := scriptErr.Value.(map[string]interface{})
+	if !ok || value["code"] != int64(7) {
+		t.Fatalf("expected thrown value, got %#v", scriptErr.Value)
+	}
+}
+
+func TestScriptError_Compile(t *testing.T) {
+	_, err := Run(`function handler() {
+	return 1 +;
+}`)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("expected ScriptError, got %v", err)
+	}
+	if scriptErr.Category != CategoryCompile || scriptErr.Line != 2 {
+		t.Fatalf("unexpected script error %+v", scriptErr)
+	}
+	t.Log(scriptErr.Message, scriptErr.Line, scriptErr.Column)
+}
+
+func TestScriptError_Category(t *testing.T) {
+	_, err := Run(`function decode() {}`)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Category != CategoryHandlerMissing {
+		t.Fatalf("expected handler missing, got %v", err)
+	}
+	if !errors.Is(err, HandlerError) {
+		t.Fatalf("expected HandlerError, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = RunWithContext(ctx, `function handler() {
+	while (true) {}
+}`)
+	if !errors.As(err, &scriptErr) || scriptErr.Category != CategoryTimeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if len(scriptErr.Stack) == 0 || scriptErr.Stack[0].Function != "handler" {
+		t.Fatalf("expected timeout in handler, got %+v", scriptErr.Stack)
+	}
+}
@@ -0,0 +1,217 @@
+package gojstest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/air-iot/gojs"
+)
+
+// DiffKind 差异类型
+type DiffKind string
+
+const (
+	// MissingResult 缺少期望的结果
+	MissingResult DiffKind = "missing-result"
+	// UnexpectedResult 多出的结果
+	UnexpectedResult DiffKind = "unexpected-result"
+	// WrongField 设备 id, 表标识或子设备 id 不一致
+	WrongField DiffKind = "wrong-field"
+	// WrongTime 时间不一致
+	WrongTime DiffKind = "wrong-time"
+	// MissingPoint 缺少期望的数据点
+	MissingPoint DiffKind = "missing-point"
+	// UnexpectedPoint 多出的数据点
+	UnexpectedPoint DiffKind = "unexpected-point"
+	// WrongType 数据点的值类型不一致
+	WrongType DiffKind = "wrong-type"
+	// WrongValue 数据点的值不一致
+	WrongValue DiffKind = "wrong-value"
+)
+
+// Diff 解析结果与期望结果的一处差异
+type Diff struct {
+	// 差异类型
+	Kind DiffKind
+	// 结果在数组中的下标
+	Index int
+	// 字段名, 数据点差异时为数据点标识
+	Field string
+	// 期望值
+	Expected interface{}
+	// 实际值
+	Actual interface{}
+}
+
+func (d Diff) String() string {
+	switch d.Kind {
+	case MissingResult:
+		return fmt.Sprintf("[%d] missing result %+v", d.Index, d.Expected)
+	case UnexpectedResult:
+		return fmt.Sprintf("[%d] unexpected result %+v", d.Index, d.Actual)
+	case MissingPoint:
+		return fmt.Sprintf("[%d] missing point %s, expected %v", d.Index, d.Field, d.Expected)
+	case UnexpectedPoint:
+		return fmt.Sprintf("[%d] unexpected point %s = %v", d.Index, d.Field, d.Actual)
+	case WrongType:
+		return fmt.Sprintf("[%d] %s: expected %s %v, got %s %v", d.Index, d.Field, valueType(d.Expected), d.Expected, valueType(d.Actual), d.Actual)
+	default:
+		return fmt.Sprintf("[%d] %s: expected %v, got %v", d.Index, d.Field, d.Expected, d.Actual)
+	}
+}
+
+// Compare 按下标比较解析结果与期望结果
+// 期望结果的 Time 为 0 时不比较时间, 数值类型的值按数值比较, 不区分整数和浮点数
+func Compare(expected, actual []gojs.ParseResult) []Diff {
+	var diffs []Diff
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			diffs = append(diffs, Diff{Kind: MissingResult, Index: i, Expected: expected[i]})
+		case i >= len(expected):
+			diffs = append(diffs, Diff{Kind: UnexpectedResult, Index: i, Actual: actual[i]})
+		default:
+			diffs = append(diffs, compareResult(i, expected[i], actual[i])...)
+		}
+	}
+	return diffs
+}
+
+func compareResult(index int, expected, actual gojs.ParseResult) []Diff {
+	var diffs []Diff
+	fields := []struct {
+		name             string
+		expected, actual string
+	}{
+		{"id", expected.ID, actual.ID},
+		{"table", expected.Table, actual.Table},
+		{"cid", expected.CID, actual.CID},
+	}
+	for _, field := range fields {
+		if field.expected != field.actual {
+			diffs = append(diffs, Diff{Kind: WrongField, Index: index, Field: field.name, Expected: field.expected, Actual: field.actual})
+		}
+	}
+	if expected.Time != 0 && expected.Time != actual.Time {
+		diffs = append(diffs, Diff{Kind: WrongTime, Index: index, Field: "time", Expected: expected.Time, Actual: actual.Time})
+	}
+	for _, key := range sortedKeys(expected.Values) {
+		want := expected.Values[key]
+		got, ok := actual.Values[key]
+		if !ok {
+			diffs = append(diffs, Diff{Kind: MissingPoint, Index: index, Field: key, Expected: want})
+			continue
+		}
+		// json 中无法表示 Buffer, 期望值可以写为十六进制字符串
+		if s, ok := want.(string); ok {
+			if bs, isBytes := got.([]byte); isBytes {
+				b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+				if err != nil || !bytes.Equal(b, bs) {
+					diffs = append(diffs, Diff{Kind: WrongValue, Index: index, Field: key, Expected: s, Actual: hex.EncodeToString(bs)})
+				}
+				continue
+			}
+		}
+		if valueType(want) != valueType(got) {
+			diffs = append(diffs, Diff{Kind: WrongType, Index: index, Field: key, Expected: want, Actual: got})
+			continue
+		}
+		if !equalValue(want, got) {
+			diffs = append(diffs, Diff{Kind: WrongValue, Index: index, Field: key, Expected: want, Actual: got})
+		}
+	}
+	for _, key := range sortedKeys(actual.Values) {
+		if _, ok := expected.Values[key]; !ok {
+			diffs = append(diffs, Diff{Kind: UnexpectedPoint, Index: index, Field: key, Actual: actual.Values[key]})
+		}
+	}
+	return diffs
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueType 返回值的 js 类型名, Buffer 为 bytes
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	}
+	if _, ok := toFloat(v); ok {
+		return "number"
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func equalValue(expected, actual interface{}) bool {
+	if e, ok := toFloat(expected); ok {
+		a, _ := toFloat(actual)
+		return e == a
+	}
+	if e, ok := expected.([]byte); ok {
+		return bytes.Equal(e, actual.([]byte))
+	}
+	return reflect.DeepEqual(normalize(expected), normalize(actual))
+}
+
+// normalize 将数组和对象中的数值统一转换为 float64
+func normalize(v interface{}) interface{} {
+	if f, ok := toFloat(v); ok {
+		return f
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if _, ok := v.([]byte); ok {
+			return v
+		}
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = normalize(rv.Index(i).Interface())
+		}
+		return values
+	case reflect.Map:
+		values := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			values[fmt.Sprint(iter.Key().Interface())] = normalize(iter.Value().Interface())
+		}
+		return values
+	}
+	return v
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
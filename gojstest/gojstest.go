@@ -0,0 +1,245 @@
+// Package gojstest 提供数据处理脚本的回归测试工具
+// 使用输入数据执行脚本, 解析返回值并与期望的 []gojs.ParseResult 逐字段比较
+package gojstest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/air-iot/gojs"
+	"github.com/dop251/goja"
+)
+
+// Payload 脚本入口函数的一个参数
+type Payload struct {
+	// Hex 十六进制字符串, 以 Buffer 传入脚本
+	Hex string `json:"hex,omitempty"`
+	// JSON json 数据, 解析后传入脚本
+	JSON json.RawMessage `json:"json,omitempty"`
+}
+
+// Hex 返回以 Buffer 传入脚本的参数, 可以包含空格
+func Hex(s string) Payload {
+	return Payload{Hex: s}
+}
+
+// JSON 返回解析后传入脚本的参数
+func JSON(s string) Payload {
+	return Payload{JSON: json.RawMessage(s)}
+}
+
+// Case 一个测试用例
+type Case struct {
+	// 用例名称
+	Name string `json:"name"`
+	// 入口函数的参数
+	Inputs []Payload `json:"inputs"`
+	// 期望的解析结果, Time 为 0 时不比较时间, Buffer 类型的数据点可以写为十六进制字符串
+	Expected []gojs.ParseResult `json:"expected"`
+	// 脚本中 Date.now() 和 moment() 的时间, 不为零时覆盖 SetDeterministic 的 Clock
+	Now time.Time `json:"now,omitempty"`
+}
+
+// Fixture 测试数据文件的内容
+type Fixture struct {
+	// 脚本内容
+	Script string `json:"script"`
+	// 脚本文件路径, Script 为空时读取, 相对于测试数据文件所在目录
+	ScriptFile string `json:"scriptFile,omitempty"`
+	// 测试用例
+	Cases []Case `json:"cases"`
+}
+
+// LoadFixture 读取 json 格式的测试数据文件
+func LoadFixture(path string) (*Fixture, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fixture %s err,%s", path, err)
+	}
+	fixture := new(Fixture)
+	if err := json.Unmarshal(bs, fixture); err != nil {
+		return nil, fmt.Errorf("unmarshal fixture %s err,%s", path, err)
+	}
+	if fixture.Script == "" && fixture.ScriptFile != "" {
+		scriptPath := fixture.ScriptFile
+		if !filepath.IsAbs(scriptPath) {
+			scriptPath = filepath.Join(filepath.Dir(path), scriptPath)
+		}
+		script, err := os.ReadFile(scriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("read script %s err,%s", scriptPath, err)
+		}
+		fixture.Script = string(script)
+	}
+	return fixture, nil
+}
+
+type options struct {
+	Engine        *gojs.Engine
+	Parser        *gojs.Parser
+	Deterministic *gojs.Deterministic
+}
+
+// Option 定义配置项
+type Option func(*options)
+
+// SetEngine 设置执行脚本的引擎, 默认为 gojs.DefaultEngine()
+func SetEngine(e *gojs.Engine) Option {
+	return func(o *options) {
+		o.Engine = e
+	}
+}
+
+// SetParser 设置解析脚本返回值的 Parser, 默认为 gojs.NewParser()
+func SetParser(p *gojs.Parser) Option {
+	return func(o *options) {
+		o.Parser = p
+	}
+}
+
+// SetDeterministic 设置执行用例时的时间和随机数来源, 每个用例的随机数序列都从 Seed 开始
+// 不设置时只有指定了 Now 的用例使用固定时间
+func SetDeterministic(d gojs.Deterministic) Option {
+	return func(o *options) {
+		o.Deterministic = &d
+	}
+}
+
+// Runner 执行测试用例并比较结果
+type Runner struct {
+	o options
+}
+
+func NewRunner(opts ...Option) *Runner {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.Engine == nil {
+		o.Engine = gojs.DefaultEngine()
+	}
+	if o.Parser == nil {
+		o.Parser = gojs.NewParser()
+	}
+	return &Runner{o: o}
+}
+
+// Report 一个测试用例的执行结果
+type Report struct {
+	// 用例名称
+	Name string
+	// 脚本返回值的解析结果
+	Results []gojs.ParseResult
+	// 与期望结果的差异
+	Diffs []Diff
+}
+
+// OK 解析结果与期望结果一致时返回 true
+func (r *Report) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+// runSeq 区分每次执行的脚本 id
+var runSeq atomic.Int64
+
+// Run 使用用例的输入执行脚本, 并比较解析结果
+// 每次执行使用独立的脚本 id, 即独立的 VM 和 _state, 执行结束后从 Engine 中移出, 执行或解析失败时返回错误
+func (r *Runner) Run(script string, c Case) (*Report, error) {
+	id := fmt.Sprintf("gojstest-%x-%d", md5.Sum([]byte(script)), runSeq.Add(1))
+	jsVM, err := r.o.Engine.GetJsVm(id, script)
+	if err != nil {
+		return nil, err
+	}
+	defer r.o.Engine.Invalidate(id)
+	args := make([]interface{}, len(c.Inputs))
+	for i, input := range c.Inputs {
+		args[i], err = input.value(jsVM.VM)
+		if err != nil {
+			return nil, fmt.Errorf("input %d err,%s", i, err)
+		}
+	}
+	ctx := context.Background()
+	if r.o.Deterministic != nil || !c.Now.IsZero() {
+		var d gojs.Deterministic
+		if r.o.Deterministic != nil {
+			d = *r.o.Deterministic
+		}
+		if !c.Now.IsZero() {
+			d.Clock = gojs.FixedClock(c.Now)
+		}
+		ctx = gojs.NewDeterministicContext(ctx, d)
+	}
+	val, err := r.o.Engine.RunByIdAndScriptWithContext(ctx, id, script, args...)
+	if err != nil {
+		return nil, err
+	}
+	results, err := r.o.Parser.Parse(val)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{Name: c.Name, Results: results, Diffs: Compare(c.Expected, results)}, nil
+}
+
+// value 将参数转换为脚本中的值, Buffer 只能在创建它的 VM 中使用, 执行时会使用脚本的主 VM
+func (p Payload) value(vm *goja.Runtime) (interface{}, error) {
+	switch {
+	case p.Hex != "" && len(p.JSON) > 0:
+		return nil, fmt.Errorf("hex and json are both set")
+	case p.Hex != "":
+		bs, err := hex.DecodeString(strings.Join(strings.Fields(p.Hex), ""))
+		if err != nil {
+			return nil, err
+		}
+		return gojs.BytesToBuffer(vm, bs)
+	case len(p.JSON) > 0:
+		var v interface{}
+		if err := json.Unmarshal(p.JSON, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return nil, nil
+	}
+}
+
+// RunCases 在子测试中执行用例, 解析结果与期望结果不一致时测试失败
+func RunCases(t *testing.T, script string, cases []Case, opts ...Option) {
+	t.Helper()
+	r := NewRunner(opts...)
+	for i, c := range cases {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("case-%d", i)
+		}
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			report, err := r.Run(script, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, diff := range report.Diffs {
+				t.Error(diff.String())
+			}
+		})
+	}
+}
+
+// RunFixture 读取测试数据文件并执行其中的用例
+func RunFixture(t *testing.T, path string, opts ...Option) {
+	t.Helper()
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunCases(t, fixture.Script, fixture.Cases, opts...)
+}
@@ -0,0 +1,98 @@
+package gojstest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/air-iot/gojs"
+)
+
+func TestRunFixture(t *testing.T) {
+	RunFixture(t, "testdata/sensor.json")
+}
+
+func TestRunner_Diffs(t *testing.T) {
+	script, err := os.ReadFile("testdata/sensor.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewRunner().Run(string(script), Case{
+		Name:   "diffs",
+		Inputs: []Payload{JSON(`"sensor/dev1"`), Hex("00fa003c")},
+		Expected: []gojs.ParseResult{
+			{ID: "dev1", Time: 1, Values: map[string]interface{}{"temperature": "25", "humidity": 61, "status": 1, "raw": []byte{0, 0xfa}}},
+			{ID: "dev2", Values: map[string]interface{}{}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[DiffKind]string{
+		WrongTime:     "time",
+		WrongType:     "temperature",
+		WrongValue:    "humidity",
+		MissingPoint:  "status",
+		MissingResult: "",
+	}
+	if len(report.Diffs) != len(want) {
+		t.Fatalf("expected %d diffs, got %v", len(want), report.Diffs)
+	}
+	for _, diff := range report.Diffs {
+		if field, ok := want[diff.Kind]; !ok || field != diff.Field {
+			t.Errorf("unexpected diff %s", diff)
+		}
+	}
+}
+
+func TestRunner_Deterministic(t *testing.T) {
+	script := `function handler(topic) {
+	return [{id: topic, time: Date.now(), values: {r: Math.floor(Math.random() * 1000000), id: uuid.v4()}}];
+}`
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRunner(SetDeterministic(gojs.Deterministic{Seed: 1}))
+	c := Case{Name: "now", Inputs: []Payload{JSON(`"dev1"`)}, Now: now}
+	first, err := r.Run(script, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Run(script, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Results[0].Time != now.UnixMilli() {
+		t.Fatalf("expected time %d, got %d", now.UnixMilli(), first.Results[0].Time)
+	}
+	if diffs := Compare(first.Results, second.Results); len(diffs) > 0 {
+		t.Fatalf("expected reproducible results, got %v", diffs)
+	}
+}
+
+func TestRunner_IsolatedState(t *testing.T) {
+	script := `function handler() {
+	_state.n = (_state.n || 0) + 1;
+	return [{id: "dev1", values: {n: _state.n}}];
+}`
+	e, err := gojs.NewEngine(gojs.SetStateStore(gojs.NewMemoryStateStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Case{
+		Name:     "state",
+		Expected: []gojs.ParseResult{{ID: "dev1", Values: map[string]interface{}{"n": 1}}},
+	}
+	for _, r := range []*Runner{NewRunner(), NewRunner(SetEngine(e))} {
+		for i := 0; i < 2; i++ {
+			report, err := r.Run(script, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK() {
+				t.Fatalf("expected fresh state on run %d, got %v", i, report.Diffs)
+			}
+		}
+	}
+	if infos := e.List(); len(infos) != 0 {
+		t.Fatalf("expected runner to remove its scripts, got %+v", infos)
+	}
+}
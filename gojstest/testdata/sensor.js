@@ -0,0 +1,12 @@
+function handler(topic, message) {
+	var id = topic.split("/")[1];
+	return [{
+		id: id,
+		time: 1700000000000,
+		values: {
+			temperature: message.readInt16BE(0) / 10,
+			humidity: message.readUInt16BE(2),
+			raw: message.slice(0, 2)
+		}
+	}];
+}
@@ -0,0 +1,162 @@
+package gojs
+
+import (
+	"fmt"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+// library 内置 js 库
+// 库在 VM 中以全局变量和 require 模块的形式延迟加载, 第一次访问时才执行库的代码
+type library struct {
+	// 库文件路径
+	path string
+	// 库执行后定义的全局变量
+	global string
+	// 全局变量的别名
+	aliases []string
+	// require 时使用的模块名
+	module string
+	// 库执行后的初始化, 返回值作为全局变量的值, require 时仍返回库执行后的原始值
+	setup func(vm *goja.Runtime, value goja.Value) (goja.Value, error)
+}
+
+// libraries 内置 js 库的定义, key 为库文件路径
+var libraries = map[string]*library{
+	"packages/bcd.js":        {path: "packages/bcd.js", global: "bcd", module: "bcd"},
+	"packages/buffer.js":     {path: "packages/buffer.js", global: "Buffer", module: "buffer", setup: setupBuffer},
+	"packages/lodash.js":     {path: "packages/lodash.js", global: "lodash", aliases: []string{"_"}, module: "lodash"},
+	"packages/crypto-js.js":  {path: "packages/crypto-js.js", global: "cryptoJs", aliases: []string{"CryptoJS"}, module: "crypto-js"},
+	"packages/moment.js":     {path: "packages/moment.js", global: "moment", module: "moment"},
+	"packages/xml-js.js":     {path: "packages/xml-js.js", global: "xmlJs", module: "xml-js"},
+	"packages/formulajs.js":  {path: "packages/formulajs.js", global: "formulajsformulajs", aliases: []string{"formulajs"}, module: "formulajs"},
+	"packages/iconv-lite.js": {path: "packages/iconv-lite.js", global: "iconvLite", aliases: []string{"iconv"}, module: "iconv-lite"},
+	"packages/forge.js":      {path: "packages/forge.js", global: "forge", module: "node-forge"},
+	"packages/pako.min.js":   {path: "packages/pako.min.js", global: "pako", module: "pako"},
+}
+
+// librarySymbol VM 全局对象上保存 libraryLoader 的属性, 脚本中无法访问
+var librarySymbol = goja.NewSymbol("gojs.libraries")
+
+// libraryLoader 负责单个 VM 中内置库的延迟加载
+type libraryLoader struct {
+	vm *goja.Runtime
+	// values 已加载库的全局变量值
+	values map[*library]goja.Value
+	// exports 已加载库执行后的原始值, 作为 require 模块的导出
+	exports map[*library]goja.Value
+}
+
+// enableLibraries 在 VM 中以访问器属性定义内置库的全局变量
+func enableLibraries(vm *goja.Runtime, libs []*library) error {
+	l := &libraryLoader{
+		vm:      vm,
+		values:  make(map[*library]goja.Value, len(libs)),
+		exports: make(map[*library]goja.Value, len(libs)),
+	}
+	global := vm.GlobalObject()
+	if err := global.DefineDataPropertySymbol(librarySymbol, vm.ToValue(l), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE); err != nil {
+		return err
+	}
+	for _, lib := range libs {
+		lib := lib
+		getter := vm.ToValue(func(goja.FunctionCall) goja.Value {
+			value, err := l.load(lib)
+			if err != nil {
+				panic(vm.NewGoError(err))
+			}
+			return value
+		})
+		for _, name := range lib.names() {
+			name := name
+			// 脚本中对同名全局变量赋值时覆盖该变量, 不加载库
+			setter := vm.ToValue(func(call goja.FunctionCall) goja.Value {
+				_ = global.DefineDataProperty(name, call.Argument(0), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+				return goja.Undefined()
+			})
+			if err := global.DefineAccessorProperty(name, getter, setter, goja.FLAG_TRUE, goja.FLAG_TRUE); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// registerLibraryModules 将内置库注册为 require 模块
+func registerLibraryModules(registry *require.Registry, libs []*library) {
+	for _, lib := range libs {
+		lib := lib
+		registry.RegisterNativeModule(lib.module, func(vm *goja.Runtime, module *goja.Object) {
+			l, ok := vm.GlobalObject().GetSymbol(librarySymbol).Export().(*libraryLoader)
+			if !ok {
+				panic(vm.NewGoError(fmt.Errorf("模块 %s 未启用", lib.module)))
+			}
+			if _, err := l.load(lib); err != nil {
+				panic(vm.NewGoError(err))
+			}
+			_ = module.Set("exports", l.exports[lib])
+		})
+	}
+}
+
+func (lib *library) names() []string {
+	return append([]string{lib.global}, lib.aliases...)
+}
+
+// load 执行库的代码, 并将全局变量及其别名替换为普通属性
+func (l *libraryLoader) load(lib *library) (goja.Value, error) {
+	if value, ok := l.values[lib]; ok {
+		return value, nil
+	}
+	p, err := compilePackage(lib.path)
+	if err != nil {
+		return nil, err
+	}
+	global := l.vm.GlobalObject()
+	for _, name := range lib.names() {
+		_ = global.Delete(name)
+	}
+	if _, err := l.vm.RunProgram(p); err != nil {
+		return nil, fmt.Errorf("load %s err,%s", lib.path, err)
+	}
+	exports := l.vm.Get(lib.global)
+	value := exports
+	if lib.setup != nil {
+		value, err = lib.setup(l.vm, exports)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range lib.names() {
+		if err := global.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	l.values[lib] = value
+	l.exports[lib] = exports
+	return value, nil
+}
+
+// setupBuffer 使用 buffer 模块中的 Buffer 作为全局 Buffer, 并添加 BigInt 读写方法
+func setupBuffer(vm *goja.Runtime, value goja.Value) (goja.Value, error) {
+	bufferModule, ok := value.(*goja.Object)
+	if !ok {
+		return nil, fmt.Errorf("buffer 模块加载失败")
+	}
+	bufferObj, ok := bufferModule.Get("Buffer").(*goja.Object)
+	if !ok {
+		return nil, fmt.Errorf("buffer 模块中未找到 Buffer")
+	}
+	bufferPrototype := bufferObj.Get("prototype").(*goja.Object)
+	_ = bufferPrototype.Set("readBigInt64LE", readBigInt64LE(vm))
+	_ = bufferPrototype.Set("readBigInt64BE", readBigInt64BE(vm))
+	_ = bufferPrototype.Set("writeBigInt64LE", writeBigInt64LE(vm))
+	_ = bufferPrototype.Set("writeBigInt64BE", writeBigInt64BE(vm))
+
+	_ = bufferPrototype.Set("readBigUInt64LE", readBigUInt64LE(vm))
+	_ = bufferPrototype.Set("readBigUInt64BE", readBigUInt64BE(vm))
+	_ = bufferPrototype.Set("writeBigUInt64LE", writeBigUInt64LE(vm))
+	_ = bufferPrototype.Set("writeBigUInt64BE", writeBigUInt64BE(vm))
+	return bufferObj, nil
+}
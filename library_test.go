@@ -0,0 +1,77 @@
+package gojs
+
+import (
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestLibrary_Lazy(t *testing.T) {
+	vm, err := GetVm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, ok := vm.GlobalObject().GetSymbol(librarySymbol).Export().(*libraryLoader)
+	if !ok {
+		t.Fatal("library loader not found")
+	}
+	if len(l.values) != 0 {
+		t.Fatalf("expected no library loaded, got %d", len(l.values))
+	}
+	val, err := vm.RunString(`Buffer.from("abc").toString("hex")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "616263" {
+		t.Fatalf("expected 616263, got %s", val)
+	}
+	if len(l.values) != 1 {
+		t.Fatalf("expected only Buffer loaded, got %d", len(l.values))
+	}
+	val, err = vm.RunString(`_ === lodash && typeof _.max`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "function" {
+		t.Fatalf("expected lodash alias, got %s", val)
+	}
+}
+
+func TestLibrary_Require(t *testing.T) {
+	val, err := Run(`function handler() {
+	const { Buffer } = require("buffer");
+	const m = require("moment");
+	return [Buffer.from([1, 2]).readUInt16BE(0), m === moment, require("lodash").max([1, 3])];
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := val.Export().([]interface{})
+	if got[0] != int64(258) || got[1] != true || got[2] != int64(3) {
+		t.Fatalf("unexpected require result %v", got)
+	}
+}
+
+func TestLibrary_Shadow(t *testing.T) {
+	vm, err := GetVm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunString(`var moment = function() { return "mine"; }`); err != nil {
+		t.Fatal(err)
+	}
+	val, err := vm.RunString(`moment()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.String() != "mine" {
+		t.Fatalf("expected user defined moment, got %s", val)
+	}
+	l := vm.GlobalObject().GetSymbol(librarySymbol).Export().(*libraryLoader)
+	if len(l.values) != 0 {
+		t.Fatalf("expected moment not loaded, got %d", len(l.values))
+	}
+	if _, ok := vm.Get("pako").(*goja.Object); !ok {
+		t.Fatal("expected pako to be loaded")
+	}
+}
@@ -0,0 +1,146 @@
+package gojs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+var EngineClosedError = errors.New400Response(100040021, "脚本引擎已关闭")
+
+// ScriptInfo 缓存的脚本 VM 的信息
+type ScriptInfo struct {
+	// 脚本 id
+	ID string
+	// 脚本内容的 md5
+	Hash string
+	// 最后一次执行的时间, 未执行过时为加载时间
+	LastUsed time.Time
+	// 当前 VM 加载后的执行次数, 包括执行失败的次数, 不包括熔断中未执行的次数, 脚本内容变化重新加载后重新计数
+	Runs int64
+	// 熔断状态, 未设置 SetCircuitBreaker 时为 BreakerClosed
+	Breaker BreakerState
+	// 连续失败的次数
+	Failures int
+}
+
+// Preload 加载指定 id 的脚本到缓存但不执行, 用于启动时预热脚本, 脚本已加载且内容未变化时不做处理
+// 只创建 VM 池中的主 VM, 其他 VM 在并发执行时创建
+func (e *Engine) Preload(id, script string) error {
+	return e.PreloadWithContext(context.Background(), id, script)
+}
+
+// PreloadWithContext 同 Preload, ctx 超时或取消时中断脚本顶层代码的执行
+func (e *Engine) PreloadWithContext(ctx context.Context, id, script string) error {
+	_, err := e.getJsVm(ctx, id, script)
+	return err
+}
+
+// Invalidate 从缓存中移出指定 id 的脚本 VM, 并以 EvictInvalidated 回调 EvictionHandler, 脚本不存在时返回 false
+// 同时删除该 id 的执行指标, 熔断状态和 SetStateStore 中以 id 为 key 保存的 _state, 删除 _state 失败时记录日志
+// 移出后 SetObj 设置的对象不再保留, SetScriptTTL 和 PinScript 的设置保留, 正在进行的执行不受影响
+func (e *Engine) Invalidate(id string) bool {
+	unlock := e.loadLocks.lock(id)
+	defer unlock()
+	_, ok := e.cache.remove(id, EvictInvalidated)
+	// 未缓存时 evicted 不会被调用
+	e.metrics.remove(id)
+	if store := e.o.StateStore; store != nil {
+		unlockState := e.stateLocks.lock(id)
+		if err := store.Delete(id); err != nil {
+			e.o.Logger.Error("删除脚本 ", id, " 的_state失败: ", err)
+		}
+		unlockState()
+	}
+	return ok
+}
+
+// List 按最近使用顺序返回缓存的脚本 VM 的信息
+func (e *Engine) List() []ScriptInfo {
+	vms := e.cache.values()
+	infos := make([]ScriptInfo, len(vms))
+	for i, jsVM := range vms {
+		infos[i] = ScriptInfo{
+			ID:       jsVM.id,
+			Hash:     jsVM.Hash,
+			LastUsed: time.Unix(0, jsVM.usedAt.Load()),
+			Runs:     jsVM.runs.Load(),
+		}
+		infos[i].Breaker, infos[i].Failures = e.breakers.state(jsVM.id, jsVM.Hash)
+	}
+	return infos
+}
+
+// Close 关闭引擎, 结束清理过期脚本的协程, 移出所有缓存的脚本 VM 并以 EvictClosed 回调 EvictionHandler
+// 然后等待正在进行的执行结束, 包括已被替换或通过 Invalidate 移出的 VM 中的执行
+// 关闭后加载和执行脚本返回 EngineClosedError, 重复关闭时直接返回
+func (e *Engine) Close() error {
+	return e.CloseWithContext(context.Background())
+}
+
+// CloseWithContext 同 Close, ctx 超时或取消时不再等待正在进行的执行, 返回超时错误
+func (e *Engine) CloseWithContext(ctx context.Context) error {
+	if !e.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(e.stop)
+	for _, jsVM := range e.cache.clear(EvictClosed) {
+		if jsVM.pool != nil {
+			jsVM.pool.stop()
+		}
+	}
+	if err := e.runs.close(ctx); err != nil {
+		return errors.Wrap400Response(err, 100040022, "等待脚本执行结束超时")
+	}
+	return nil
+}
+
+// runGroup 记录正在进行的执行, 关闭后不再接受新的执行
+type runGroup struct {
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// add 开始一次执行, 已关闭时返回 false
+func (g *runGroup) add() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closed {
+		return false
+	}
+	g.wg.Add(1)
+	return true
+}
+
+// done 结束一次执行
+func (g *runGroup) done() {
+	g.wg.Done()
+}
+
+// close 不再接受新的执行, 并等待正在进行的执行结束, ctx 结束时不再等待, 返回 ctx 的错误
+func (g *runGroup) close(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observeRun 记录一次执行的指标和脚本 VM 的执行次数
+func (j *JSvm) observeRun(start time.Time, err error) {
+	j.runs.Add(1)
+	j.usedAt.Store(time.Now().UnixNano())
+	j.engine.metrics.observeRun(j.id, start, err)
+}
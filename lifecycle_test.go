@@ -0,0 +1,254 @@
+package gojs
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestEngine_PreloadAndList(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(v) {
+	return v * 2;
+}`
+	for _, id := range []string{"a", "b"} {
+		if err := e.Preload(id, js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Preload("bad", `function handler( {`); err == nil {
+		t.Fatal("expected compile error")
+	}
+	val, err := e.RunById("a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 4 {
+		t.Fatalf("expected 4, got %s", val)
+	}
+	infos := e.List()
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 scripts, got %+v", infos)
+	}
+	// 最近使用的 a 在前
+	if infos[0].ID != "a" || infos[0].Runs != 1 || infos[0].Hash != scriptHash(js) {
+		t.Fatalf("unexpected info %+v", infos[0])
+	}
+	if infos[1].ID != "b" || infos[1].Runs != 0 || infos[1].LastUsed.After(infos[0].LastUsed) {
+		t.Fatalf("unexpected info %+v", infos[1])
+	}
+}
+
+func TestEngine_Invalidate(t *testing.T) {
+	var rec evictRecorder
+	e, err := NewEngine(SetEvictionHandler(rec.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Preload("a", `function handler() {
+	return 1;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Invalidate("a") {
+		t.Fatal("expected a to be invalidated")
+	}
+	if e.Invalidate("a") {
+		t.Fatal("expected a to be missing")
+	}
+	if _, err := e.RunById("a"); err == nil {
+		t.Fatal("expected vm not found")
+	}
+	if records := rec.list(); len(records) != 1 || records[0] != (evictRecord{id: "a", reason: EvictInvalidated}) {
+		t.Fatalf("expected a invalidated, got %v", records)
+	}
+}
+
+func TestEngine_InvalidateClears(t *testing.T) {
+	store := NewMemoryStateStore()
+	e, err := NewEngine(SetStateStore(store), SetCircuitBreaker(CircuitBreaker{Failures: 1, CoolDown: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(fail) {
+	_state.runs = (_state.runs || 0) + 1;
+	if (fail) {
+		throw new Error("bad frame");
+	}
+	return _state.runs;
+}`
+	if _, err := e.RunByIdAndScript("a", js, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScript("a", js, true); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := e.RunByIdAndScript("a", js, false); err != CircuitOpenError {
+		t.Fatalf("expected circuit open, got %v", err)
+	}
+	e.Invalidate("a")
+	if state, _ := store.Load("a"); state != nil {
+		t.Fatalf("expected state of a to be deleted, got %v", state)
+	}
+	if stats := e.Stats(); len(stats) != 0 {
+		t.Fatalf("expected stats of a to be removed, got %v", stats)
+	}
+	// 熔断状态已删除, 重新加载后从头计数
+	val, err := e.RunByIdAndScript("a", js, false)
+	if err != nil {
+		t.Fatalf("expected breaker of a to be removed, got %v", err)
+	}
+	if val.ToInteger() != 1 {
+		t.Fatalf("expected state to restart, got %s", val)
+	}
+}
+
+func TestEngine_CloseRetired(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := e.GetJsVm("slow", `function handler() {
+	started();
+	wait();
+	return 1;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	var finished atomic.Bool
+	if err := old.SetObj("started", func() { close(started) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.SetObj("wait", func() {
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := e.RunById("slow")
+		errCh <- err
+	}()
+	<-started
+	// 执行中的 VM 被替换后不在缓存中, Close 仍需等待
+	if _, err := e.RunByIdAndScript("slow", `function handler() {
+	return 2;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Fatal("expected close to wait for running script on replaced vm")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.call(context.Background(), ""); err == nil {
+		t.Fatal("expected replaced vm to reject runs after close")
+	}
+}
+
+func TestEngine_Close(t *testing.T) {
+	var rec evictRecorder
+	e, err := NewEngine(SetPoolSize(2), SetEvictionHandler(rec.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsVM, err := e.GetJsVm("slow", `function handler() {
+	started();
+	wait();
+	return 1;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	var finished atomic.Bool
+	if err := jsVM.SetObj("started", func() { close(started) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsVM.SetObj("wait", func() {
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := e.RunById("slow")
+		errCh <- err
+	}()
+	<-started
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Fatal("expected close to wait for running script")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if records := rec.list(); len(records) != 1 || records[0] != (evictRecord{id: "slow", reason: EvictClosed}) {
+		t.Fatalf("expected slow closed, got %v", records)
+	}
+	if _, err := e.RunById("slow"); err != EngineClosedError {
+		t.Fatalf("expected engine closed, got %v", err)
+	}
+	if _, err := e.RunByIdAndScript("other", `function handler() {}`); err != EngineClosedError {
+		t.Fatalf("expected engine closed, got %v", err)
+	}
+	// 关闭前获取的 VM 也不能再执行
+	if _, err := jsVM.call(context.Background(), ""); err == nil {
+		t.Fatal("expected closed vm to reject runs")
+	} else if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040021 {
+		t.Fatalf("expected closed vm to reject runs, got %v", resErr)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEngine_CloseDuringLoad(t *testing.T) {
+	var rec evictRecorder
+	started, release := make(chan struct{}), make(chan struct{})
+	e, err := NewEngine(SetEvictionHandler(rec.handle), SetGlobal("hold", func() {
+		close(started)
+		<-release
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := e.GetJsVm("loading", `hold();
+function handler() {
+	return 1;
+}`)
+		errCh <- err
+	}()
+	<-started
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-errCh; err != EngineClosedError {
+		t.Fatalf("expected engine closed, got %v", err)
+	}
+	if infos := e.List(); len(infos) != 0 {
+		t.Fatalf("expected no cached scripts after close, got %+v", infos)
+	}
+	if records := rec.list(); len(records) != 1 || records[0] != (evictRecord{id: "loading", reason: EvictClosed}) {
+		t.Fatalf("expected loading closed, got %v", records)
+	}
+}
@@ -11,6 +11,8 @@ const Key = "logger"
 
 type Log struct {
 	o options
+	// ctx 日志的上下文, 如携带 trace id 的 ctx
+	ctx context.Context
 }
 
 type options struct {
@@ -59,6 +61,20 @@ func NewLogger(opts ...Option) *Log {
 	return &Log{o: o}
 }
 
+// With 返回在当前配置上应用 opts 的新日志, 当前日志不变
+func (l *Log) With(opts ...Option) *Log {
+	o := l.o
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Log{o: o, ctx: l.ctx}
+}
+
+// WithContext 返回使用 ctx 的新日志, 日志中携带 ctx 中的 trace id
+func (l *Log) WithContext(ctx context.Context) *Log {
+	return &Log{o: l.o, ctx: ctx}
+}
+
 func (l *Log) Debug(args ...any) {
 	logger.WithContext(l.getCtx()).Debugln(args...)
 }
@@ -76,7 +92,10 @@ func (l *Log) Error(args ...any) {
 }
 
 func (l *Log) getCtx() context.Context {
-	ctx := context.Background()
+	ctx := l.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	if l.o.Key != "" {
 		ctx = logger.NewTableContext(ctx, l.o.Key)
 	}
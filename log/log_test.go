@@ -11,3 +11,15 @@ func TestNewLogger(t *testing.T) {
 	l.Warn(1)
 	l.Error(1)
 }
+
+func TestLog_With(t *testing.T) {
+	l := NewLogger(SetProject("testP"), SetModule("testM"))
+	l2 := l.With(SetKey("table1"), SetModule("script"))
+	if l2.o.Project != "testP" || l2.o.Key != "table1" || l2.o.Module != "script" {
+		t.Fatalf("unexpected options %+v", l2.o)
+	}
+	if l.o.Key != "" || l.o.Module != "testM" {
+		t.Fatalf("original logger changed %+v", l.o)
+	}
+	l2.Info("with", 1)
+}
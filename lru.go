@@ -0,0 +1,301 @@
+package gojs
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// EvictReason 脚本 VM 被移出缓存的原因
+type EvictReason string
+
+const (
+	// EvictExpired 超过过期时间未使用
+	EvictExpired EvictReason = "expired"
+	// EvictCapacity 超出缓存的最大数量或内存上限, 移出最久未使用的 VM
+	EvictCapacity EvictReason = "capacity"
+	// EvictReplaced 脚本内容变化, 旧 VM 被新加载的 VM 替换
+	EvictReplaced EvictReason = "replaced"
+	// EvictInvalidated 通过 Engine.Invalidate 移出
+	EvictInvalidated EvictReason = "invalidated"
+	// EvictClosed 通过 Engine.Close 关闭引擎时移出
+	EvictClosed EvictReason = "closed"
+)
+
+// EvictionHandler 脚本 VM 被移出缓存时的回调, 在移出后同步调用
+// 被替换和淘汰的 VM 上可能仍有正在进行的执行, 回调中不应执行该 VM 或进行耗时操作
+type EvictionHandler func(id string, jsVM *JSvm, reason EvictReason)
+
+// cachePolicy 单个 key 的缓存策略
+type cachePolicy struct {
+	// ttl 过期时间, 0 表示使用缓存的默认过期时间, 小于 0 表示不过期
+	ttl    time.Duration
+	pinned bool
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	size    int64
+	expires time.Time
+}
+
+// lruCache 按最近使用顺序淘汰的缓存, 访问时刷新过期时间
+// 过期的数据在访问时或每隔 cleanupInterval 在写入时清理, 不使用后台协程, 也可以由使用者定期调用 cleanup 清理
+type lruCache[V any] struct {
+	ttl             time.Duration
+	cleanupInterval time.Duration
+	maxEntries      int
+	maxBytes        int64
+	// size 估算数据占用的内存, 为 nil 时不限制内存
+	size    func(V) int64
+	onEvict func(key string, value V, reason EvictReason)
+
+	mu        sync.Mutex
+	ll        *list.List
+	items     map[string]*list.Element
+	policies  map[string]cachePolicy
+	bytes     int64
+	lastSweep time.Time
+}
+
+func newLRUCache[V any](ttl, cleanupInterval time.Duration, maxEntries int, maxBytes int64) *lruCache[V] {
+	return &lruCache[V]{
+		ttl:             ttl,
+		cleanupInterval: cleanupInterval,
+		maxEntries:      maxEntries,
+		maxBytes:        maxBytes,
+		ll:              list.New(),
+		items:           make(map[string]*list.Element),
+		policies:        make(map[string]cachePolicy),
+		lastSweep:       time.Now(),
+	}
+}
+
+type evicted[V any] struct {
+	key    string
+	value  V
+	reason EvictReason
+}
+
+// get 获取数据, 并标记为最近使用和刷新过期时间
+func (c *lruCache[V]) get(key string) (V, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		var zero V
+		return zero, false
+	}
+	entry := el.Value.(*lruEntry[V])
+	if c.expired(entry, now) {
+		c.removeElement(el)
+		value := entry.value
+		c.mu.Unlock()
+		c.evict([]evicted[V]{{key: key, value: value, reason: EvictExpired}})
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	entry.expires = c.expiration(key, now)
+	value := entry.value
+	c.mu.Unlock()
+	return value, true
+}
+
+// peek 获取未过期的数据, 不改变使用顺序和过期时间
+func (c *lruCache[V]) peek(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		if !c.expired(entry, time.Now()) {
+			return entry.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// set 写入数据, 替换已有数据时以 EvictReplaced 回调旧数据, 超出容量时淘汰最久未使用的数据
+func (c *lruCache[V]) set(key string, value V, replaced func(old V) bool) {
+	now := time.Now()
+	var size int64
+	if c.size != nil {
+		size = c.size(value)
+	}
+	var evictions []evicted[V]
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		if replaced != nil && replaced(entry.value) {
+			evictions = append(evictions, evicted[V]{key: key, value: entry.value, reason: EvictReplaced})
+		}
+		c.bytes += size - entry.size
+		entry.value, entry.size = value, size
+		entry.expires = c.expiration(key, now)
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value, size: size, expires: c.expiration(key, now)})
+		c.bytes += size
+	}
+	if c.cleanupInterval > 0 && now.Sub(c.lastSweep) >= c.cleanupInterval {
+		evictions = append(evictions, c.sweep(now)...)
+	}
+	evictions = append(evictions, c.shrink(key)...)
+	c.mu.Unlock()
+	c.evict(evictions)
+}
+
+// setPolicy 设置 key 的过期时间和是否固定, 对已缓存和之后写入的数据都生效
+func (c *lruCache[V]) setPolicy(key string, update func(p *cachePolicy)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	policy := c.policies[key]
+	update(&policy)
+	if policy == (cachePolicy{}) {
+		delete(c.policies, key)
+	} else {
+		c.policies[key] = policy
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry[V]).expires = c.expiration(key, time.Now())
+	}
+}
+
+// remove 移出数据并以 reason 回调, 数据不存在时返回 false
+func (c *lruCache[V]) remove(key string, reason EvictReason) (V, bool) {
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		var zero V
+		return zero, false
+	}
+	entry := el.Value.(*lruEntry[V])
+	c.removeElement(el)
+	value := entry.value
+	c.mu.Unlock()
+	c.evict([]evicted[V]{{key: key, value: value, reason: reason}})
+	return value, true
+}
+
+// clear 移出所有数据并以 reason 回调, 返回移出的数据
+func (c *lruCache[V]) clear(reason EvictReason) []V {
+	c.mu.Lock()
+	evictions := make([]evicted[V], 0, c.ll.Len())
+	values := make([]V, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*lruEntry[V])
+		evictions = append(evictions, evicted[V]{key: entry.key, value: entry.value, reason: reason})
+		values = append(values, entry.value)
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+	c.mu.Unlock()
+	c.evict(evictions)
+	return values
+}
+
+// values 按最近使用顺序返回未过期的数据, 不改变使用顺序和过期时间
+func (c *lruCache[V]) values() []V {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([]V, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		if entry := el.Value.(*lruEntry[V]); !c.expired(entry, now) {
+			values = append(values, entry.value)
+		}
+	}
+	return values
+}
+
+// len 返回缓存的数据数量, 包括已过期但未清理的数据
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// expiration 返回 key 从 now 开始的过期时间, 零值表示不过期
+func (c *lruCache[V]) expiration(key string, now time.Time) time.Time {
+	ttl := c.ttl
+	if policy, ok := c.policies[key]; ok {
+		if policy.pinned {
+			return time.Time{}
+		}
+		if policy.ttl != 0 {
+			ttl = policy.ttl
+		}
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func (c *lruCache[V]) expired(entry *lruEntry[V], now time.Time) bool {
+	return !entry.expires.IsZero() && now.After(entry.expires)
+}
+
+func (c *lruCache[V]) removeElement(el *list.Element) {
+	entry := el.Value.(*lruEntry[V])
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+// cleanup 清理过期的数据并回调
+func (c *lruCache[V]) cleanup() {
+	c.mu.Lock()
+	evictions := c.sweep(time.Now())
+	c.mu.Unlock()
+	c.evict(evictions)
+}
+
+// sweep 清理过期的数据, 调用时需持有锁
+func (c *lruCache[V]) sweep(now time.Time) []evicted[V] {
+	c.lastSweep = now
+	var evictions []evicted[V]
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if entry := el.Value.(*lruEntry[V]); c.expired(entry, now) {
+			c.removeElement(el)
+			evictions = append(evictions, evicted[V]{key: entry.key, value: entry.value, reason: EvictExpired})
+		}
+		el = prev
+	}
+	return evictions
+}
+
+// shrink 超出容量时从最久未使用的数据开始淘汰, 跳过固定的数据和刚写入的 keep, 调用时需持有锁
+func (c *lruCache[V]) shrink(keep string) []evicted[V] {
+	var evictions []evicted[V]
+	for el := c.ll.Back(); el != nil && c.overflow(); {
+		prev := el.Prev()
+		entry := el.Value.(*lruEntry[V])
+		if entry.key != keep && !c.policies[entry.key].pinned {
+			c.removeElement(el)
+			evictions = append(evictions, evicted[V]{key: entry.key, value: entry.value, reason: EvictCapacity})
+		}
+		el = prev
+	}
+	return evictions
+}
+
+func (c *lruCache[V]) overflow() bool {
+	return c.maxEntries > 0 && c.ll.Len() > c.maxEntries || c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
+// evict 在锁外调用淘汰回调
+func (c *lruCache[V]) evict(evictions []evicted[V]) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, e := range evictions {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+}
@@ -0,0 +1,217 @@
+package gojs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type evictRecord struct {
+	id     string
+	reason EvictReason
+}
+
+type evictRecorder struct {
+	mu      sync.Mutex
+	records []evictRecord
+}
+
+func (r *evictRecorder) handle(id string, _ *JSvm, reason EvictReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, evictRecord{id: id, reason: reason})
+}
+
+func (r *evictRecorder) list() []evictRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]evictRecord(nil), r.records...)
+}
+
+func TestEngine_CacheMaxEntries(t *testing.T) {
+	var rec evictRecorder
+	e, err := NewEngine(SetCacheMaxEntries(2), SetEvictionHandler(rec.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	return 1;
+}`
+	for _, id := range []string{"a", "b"} {
+		if _, err := e.RunByIdAndScript(id, js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a 最近使用, 加载 c 时淘汰 b
+	if _, err := e.RunById("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScript("c", js); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunById("b"); err == nil {
+		t.Fatal("expected b to be evicted")
+	}
+	if _, err := e.RunById("a"); err != nil {
+		t.Fatal(err)
+	}
+	if records := rec.list(); len(records) != 1 || records[0] != (evictRecord{id: "b", reason: EvictCapacity}) {
+		t.Fatalf("expected b evicted by capacity, got %v", records)
+	}
+}
+
+func TestEngine_PinScript(t *testing.T) {
+	var rec evictRecorder
+	e, err := NewEngine(SetCacheMaxEntries(1), SetEvictionHandler(rec.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	return 1;
+}`
+	e.PinScript("pinned")
+	if _, err := e.RunByIdAndScript("pinned", js); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := e.RunByIdAndScript(fmt.Sprintf("s%d", i), js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.RunById("pinned"); err != nil {
+		t.Fatalf("expected pinned script to stay cached, got %v", err)
+	}
+	if _, err := e.RunById("s2"); err != nil {
+		t.Fatalf("expected latest script to stay cached, got %v", err)
+	}
+	for _, r := range rec.list() {
+		if r.id == "pinned" {
+			t.Fatalf("pinned script evicted: %v", r)
+		}
+	}
+
+	e.UnpinScript("pinned")
+	if _, err := e.RunByIdAndScript("s3", js); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunById("pinned"); err == nil {
+		t.Fatal("expected unpinned script to be evicted")
+	}
+}
+
+func TestEngine_ScriptTTL(t *testing.T) {
+	var rec evictRecorder
+	e, err := NewEngine(SetCacheExpiration(time.Hour, time.Hour), SetEvictionHandler(rec.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	return 1;
+}`
+	e.SetScriptTTL("short", 20*time.Millisecond)
+	for _, id := range []string{"short", "long"} {
+		if _, err := e.RunByIdAndScript(id, js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := e.RunById("short"); err == nil {
+		t.Fatal("expected short to expire")
+	}
+	if _, err := e.RunById("long"); err != nil {
+		t.Fatal(err)
+	}
+	if records := rec.list(); len(records) != 1 || records[0] != (evictRecord{id: "short", reason: EvictExpired}) {
+		t.Fatalf("expected short expired, got %v", records)
+	}
+}
+
+func TestEngine_CleanupInterval(t *testing.T) {
+	var rec evictRecorder
+	e, err := NewEngine(SetCacheExpiration(20*time.Millisecond, 10*time.Millisecond), SetBackgroundCleanup(true), SetEvictionHandler(rec.handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Preload("a", `function handler() {
+	return 1;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	// 没有访问和写入时由后台协程清理
+	deadline := time.Now().Add(time.Second)
+	for len(rec.list()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if records := rec.list(); len(records) != 1 || records[0] != (evictRecord{id: "a", reason: EvictExpired}) {
+		t.Fatalf("expected a expired, got %v", records)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEngine_EvictReplaced(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		states []interface{}
+	)
+	e, err := NewEngine(SetEvictionHandler(func(id string, jsVM *JSvm, reason EvictReason) {
+		if reason != EvictReplaced {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, jsVM.VM.Get("_state").Export())
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScript("s", `function handler() {
+	_state.count = (_state.count || 0) + 1;
+	return _state.count;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	// 内容不变时不替换
+	if _, err := e.RunByIdAndScript("s", `function handler() {
+	_state.count = (_state.count || 0) + 1;
+	return _state.count;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScript("s", `function handler() {
+	return 0;
+}`); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 1 {
+		t.Fatalf("expected 1 replaced vm, got %v", states)
+	}
+	if state, ok := states[0].(map[string]interface{}); !ok || state["count"] != int64(2) {
+		t.Fatalf("expected replaced state count 2, got %v", states[0])
+	}
+}
+
+func TestEngine_CacheMaxMemory(t *testing.T) {
+	js := `function handler() {
+	return 1;
+}`
+	e, err := NewEngine(SetCacheMaxMemory(2 * (vmBaseMemory + 16*int64(len(js)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := e.RunByIdAndScript(id, js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := e.cache.len(); n != 2 {
+		t.Fatalf("expected 2 cached vms, got %d", n)
+	}
+	if _, err := e.RunById("a"); err == nil {
+		t.Fatal("expected a to be evicted")
+	}
+}
@@ -0,0 +1,200 @@
+package gojs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+// CategoryOther 非脚本本身的错误, 如等待 VM 超时, 未找到 VM 和 _state 存储失败, 只用于执行指标
+const CategoryOther ErrorCategory = "other"
+
+// latencyBuckets 执行耗时直方图的区间上限, 最后一个区间没有上限
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// MetricsSink 执行指标的接收者, 如上报到 Prometheus, 方法在执行脚本的协程中同步调用
+type MetricsSink interface {
+	// ObserveRun 一次函数执行结束, 执行成功时 category 为空
+	ObserveRun(id string, duration time.Duration, category ErrorCategory)
+	// ObserveVmCreate 创建了一个加载脚本的 VM, 包括 VM 池中的 VM
+	ObserveVmCreate(id string, duration time.Duration)
+	// ObserveCache 获取缓存的脚本 VM, hit 为 false 表示需要加载脚本或未找到
+	ObserveCache(id string, hit bool)
+}
+
+// LatencyBucket 执行耗时直方图的一个区间
+type LatencyBucket struct {
+	// 区间上限, 0 表示没有上限
+	UpperBound time.Duration `json:"upperBound"`
+	// 耗时不超过上限且大于上一个区间上限的执行次数
+	Count int64 `json:"count"`
+}
+
+// LatencyHistogram 执行耗时直方图
+type LatencyHistogram struct {
+	Buckets []LatencyBucket `json:"buckets"`
+	// 总耗时
+	Sum time.Duration `json:"sum"`
+	// 最大耗时
+	Max time.Duration `json:"max"`
+}
+
+// ScriptStats 单个脚本 id 的执行指标
+type ScriptStats struct {
+	// 执行次数
+	Invocations int64 `json:"invocations"`
+	// 按错误类别统计的失败次数
+	Errors map[ErrorCategory]int64 `json:"errors"`
+	// 执行耗时, 包括等待 VM 池的时间
+	Latency LatencyHistogram `json:"latency"`
+	// 创建 VM 的次数, 包括脚本变化后重新加载和 VM 池扩容
+	VmCreated int64 `json:"vmCreated"`
+	// 获取缓存的脚本 VM 的命中次数
+	CacheHits int64 `json:"cacheHits"`
+	// 获取缓存的脚本 VM 的未命中次数
+	CacheMisses int64 `json:"cacheMisses"`
+	// 最后一次执行的时间
+	LastRun time.Time `json:"lastRun"`
+}
+
+// MeanLatency 返回平均执行耗时
+func (s ScriptStats) MeanLatency() time.Duration {
+	if s.Invocations == 0 {
+		return 0
+	}
+	return s.Latency.Sum / time.Duration(s.Invocations)
+}
+
+// metrics 按脚本 id 记录执行指标
+// 只记录通过 track 登记的 id, 即已缓存或正在加载的脚本, 其他 id 只通知 sink, 避免未知的 id 占用内存
+type metrics struct {
+	sink MetricsSink
+
+	mu      sync.Mutex
+	scripts map[string]*ScriptStats
+}
+
+func newMetrics(sink MetricsSink) *metrics {
+	return &metrics{sink: sink, scripts: make(map[string]*ScriptStats)}
+}
+
+// track 开始记录 id 的指标, 已记录时不做处理
+func (m *metrics) track(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.scripts[id]; ok {
+		return
+	}
+	s := &ScriptStats{Errors: make(map[ErrorCategory]int64)}
+	s.Latency.Buckets = make([]LatencyBucket, len(latencyBuckets)+1)
+	for i, bound := range latencyBuckets {
+		s.Latency.Buckets[i].UpperBound = bound
+	}
+	m.scripts[id] = s
+}
+
+func (m *metrics) observeRun(id string, start time.Time, err error) {
+	duration := time.Since(start)
+	var category ErrorCategory
+	if err != nil {
+		category = errorCategory(err)
+	}
+	m.mu.Lock()
+	if s, ok := m.scripts[id]; ok {
+		s.Invocations++
+		s.LastRun = start
+		if category != "" {
+			s.Errors[category]++
+		}
+		i := 0
+		for i < len(latencyBuckets) && duration > latencyBuckets[i] {
+			i++
+		}
+		s.Latency.Buckets[i].Count++
+		s.Latency.Sum += duration
+		if duration > s.Latency.Max {
+			s.Latency.Max = duration
+		}
+	}
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.ObserveRun(id, duration, category)
+	}
+}
+
+func (m *metrics) observeVmCreate(id string, start time.Time) {
+	duration := time.Since(start)
+	m.mu.Lock()
+	if s, ok := m.scripts[id]; ok {
+		s.VmCreated++
+	}
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.ObserveVmCreate(id, duration)
+	}
+}
+
+func (m *metrics) observeCache(id string, hit bool) {
+	m.mu.Lock()
+	if s, ok := m.scripts[id]; ok {
+		if hit {
+			s.CacheHits++
+		} else {
+			s.CacheMisses++
+		}
+	}
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.ObserveCache(id, hit)
+	}
+}
+
+// remove 删除 id 的指标
+func (m *metrics) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.scripts, id)
+}
+
+// snapshot 返回所有脚本指标的副本
+func (m *metrics) snapshot() map[string]ScriptStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]ScriptStats, len(m.scripts))
+	for id, s := range m.scripts {
+		stats := *s
+		stats.Errors = make(map[ErrorCategory]int64, len(s.Errors))
+		for category, count := range s.Errors {
+			stats.Errors[category] = count
+		}
+		stats.Latency.Buckets = append([]LatencyBucket(nil), s.Latency.Buckets...)
+		snapshot[id] = stats
+	}
+	return snapshot
+}
+
+// errorCategory 返回错误的类别, 非脚本错误为 CategoryOther
+func errorCategory(err error) ErrorCategory {
+	var scriptErr *ScriptError
+	if errors.As(err, &scriptErr) {
+		return scriptErr.Category
+	}
+	return CategoryOther
+}
+
+// Stats 返回各脚本 id 执行指标的快照, key 为脚本 id
+// 只包含已缓存的脚本, 未找到的 id 和加载失败的脚本只通知 MetricsSink
+// 脚本 VM 过期, 被淘汰或通过 Invalidate 移出后不再包含该 id 的指标, 移出后结束的执行也不再记录
+func (e *Engine) Stats() map[string]ScriptStats {
+	return e.metrics.snapshot()
+}
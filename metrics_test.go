@@ -0,0 +1,149 @@
+package gojs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSink struct {
+	mu       sync.Mutex
+	runs     []ErrorCategory
+	vms      int
+	hits     int
+	misses   int
+	duration time.Duration
+}
+
+func (s *testSink) ObserveRun(id string, duration time.Duration, category ErrorCategory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, category)
+	s.duration += duration
+}
+
+func (s *testSink) ObserveVmCreate(id string, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vms++
+}
+
+func (s *testSink) ObserveCache(id string, hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hit {
+		s.hits++
+	} else {
+		s.misses++
+	}
+}
+
+func TestEngine_Stats(t *testing.T) {
+	sink := &testSink{}
+	e, err := NewEngine(SetMetricsSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(fail) {
+	if (fail) {
+		throw new Error("bad");
+	}
+	return 1;
+}`
+	if _, err := e.RunByIdAndScript("metrics", js, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScript("metrics", js, true); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := e.RunById("metrics", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScript("metrics", js+"\n", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunById("missing"); err == nil {
+		t.Fatal("expected vm not found")
+	}
+
+	stats := e.Stats()
+	s := stats["metrics"]
+	if s.Invocations != 4 || s.Errors[CategoryRuntime] != 1 || len(s.Errors) != 1 {
+		t.Fatalf("unexpected invocations %+v", s)
+	}
+	if s.VmCreated != 2 || s.CacheHits != 2 || s.CacheMisses != 2 {
+		t.Fatalf("unexpected vm and cache stats %+v", s)
+	}
+	var count int64
+	for _, bucket := range s.Latency.Buckets {
+		count += bucket.Count
+	}
+	if count != s.Invocations || s.Latency.Sum <= 0 || s.MeanLatency() > s.Latency.Max {
+		t.Fatalf("unexpected latency %+v", s.Latency)
+	}
+	if s.LastRun.IsZero() {
+		t.Fatal("expected last run time")
+	}
+	// 未缓存的 id 只通知 sink
+	if _, ok := stats["missing"]; ok {
+		t.Fatalf("unexpected stats for missing id %+v", stats["missing"])
+	}
+
+	sink.mu.Lock()
+	if len(sink.runs) != 5 || sink.vms != 2 || sink.hits != 2 || sink.misses != 3 {
+		t.Fatalf("unexpected sink observations %+v", sink)
+	}
+	if sink.runs[0] != "" || sink.runs[1] != CategoryRuntime || sink.runs[4] != CategoryOther {
+		t.Fatalf("unexpected run categories %v", sink.runs)
+	}
+	sink.mu.Unlock()
+
+	// 快照不受之后执行的影响
+	if _, err := e.RunById("metrics", false); err != nil {
+		t.Fatal(err)
+	}
+	if stats["metrics"].Invocations != 4 || e.Stats()["metrics"].Invocations != 5 {
+		t.Fatal("expected snapshot to be independent")
+	}
+}
+
+func TestEngine_StatsRemoved(t *testing.T) {
+	e, err := NewEngine(SetCacheMaxEntries(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	return 1;
+}`
+	for _, id := range []string{"a", "b"} {
+		if _, err := e.RunByIdAndScript(id, js); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := e.Stats()
+	if _, ok := stats["a"]; ok {
+		t.Fatalf("expected stats of evicted a to be removed, got %v", stats)
+	}
+	if stats["b"].Invocations != 1 {
+		t.Fatalf("expected 1 invocation of b, got %+v", stats["b"])
+	}
+	e.Invalidate("b")
+	if stats := e.Stats(); len(stats) != 0 {
+		t.Fatalf("expected stats of invalidated b to be removed, got %v", stats)
+	}
+
+	// 加载失败和未找到的 id 不记录
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("bad%d", i)
+		if _, err := e.RunByIdAndScript(id, `function handler( {`); err == nil {
+			t.Fatal("expected compile error")
+		}
+		if _, err := e.RunById(id + "-missing"); err == nil {
+			t.Fatal("expected vm not found")
+		}
+	}
+	if stats := e.Stats(); len(stats) != 0 {
+		t.Fatalf("expected no stats for unknown ids, got %v", stats)
+	}
+}
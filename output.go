@@ -0,0 +1,209 @@
+package gojs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log2 "github.com/air-iot/gojs/log"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/util"
+)
+
+// LogEntry 脚本执行时 console 或 logger 的一次输出
+type LogEntry struct {
+	// 输出来源, console 或 logger
+	Source string `json:"source"`
+	// 日志级别, console 为 log, info, debug, warn 和 error, logger 为 debug, info, warn 和 error
+	Level string `json:"level"`
+	// 输出时间
+	Time time.Time `json:"time"`
+	// 格式化后的内容
+	Message string `json:"message"`
+	// 原始参数
+	Args []interface{} `json:"args"`
+}
+
+// LogCapture 记录一次执行中脚本的 console 和 logger 输出
+type LogCapture struct {
+	lock    sync.Mutex
+	entries []LogEntry
+}
+
+type logCaptureKey struct{}
+
+// NewLogCaptureContext 返回记录脚本输出的 ctx, 使用该 ctx 执行脚本后通过 LogCapture.Entries 获取输出
+// 只记录入口函数或 RunFunction 指定函数执行期间的输出, 不包括加载脚本时顶层代码的输出
+func NewLogCaptureContext(ctx context.Context) (context.Context, *LogCapture) {
+	capture := &LogCapture{}
+	return context.WithValue(ctx, logCaptureKey{}, capture), capture
+}
+
+// FromLogCaptureContext 获取 ctx 中的 LogCapture
+func FromLogCaptureContext(ctx context.Context) (*LogCapture, bool) {
+	capture, ok := ctx.Value(logCaptureKey{}).(*LogCapture)
+	return capture, ok
+}
+
+// Entries 返回已记录的输出
+func (c *LogCapture) Entries() []LogEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries := make([]LogEntry, len(c.entries))
+	copy(entries, c.entries)
+	return entries
+}
+
+func (c *LogCapture) add(entry LogEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = append(c.entries, entry)
+}
+
+// outputSymbol VM 全局对象上保存 scriptOutput 的属性, 脚本中无法访问
+var outputSymbol = goja.NewSymbol("gojs.output")
+
+// loggerRef 脚本使用的日志, 同一脚本的 VM 池中的 VM 共享, 修改后所有 VM 立即生效
+type loggerRef = atomic.Pointer[log2.Log]
+
+func newLoggerRef(l *log2.Log) *loggerRef {
+	ref := new(loggerRef)
+	ref.Store(l)
+	return ref
+}
+
+// scriptOutput 单个 VM 中 console 和 logger 的输出
+// console 和 logger 都输出到 log.Log, 执行时设置 current, 执行期间的日志携带执行的 ctx, 输出同时记录到 capture
+type scriptOutput struct {
+	util    *util.Util
+	logger  *loggerRef
+	current atomic.Pointer[invocation]
+}
+
+// invocation 当前执行的上下文
+type invocation struct {
+	ctx     context.Context
+	capture *LogCapture
+}
+
+// enableOutput 在 VM 中设置 console 和 logger
+func enableOutput(vm *goja.Runtime, logger *loggerRef) error {
+	out := &scriptOutput{util: util.New(vm), logger: logger}
+	if err := vm.GlobalObject().DefineDataPropertySymbol(outputSymbol, vm.ToValue(out), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE); err != nil {
+		return err
+	}
+	c := vm.NewObject()
+	_ = c.Set("log", out.console("log", (*log2.Log).Info))
+	_ = c.Set("info", out.console("info", (*log2.Log).Info))
+	_ = c.Set("debug", out.console("debug", (*log2.Log).Debug))
+	_ = c.Set("warn", out.console("warn", (*log2.Log).Warn))
+	_ = c.Set("error", out.console("error", (*log2.Log).Error))
+	if err := vm.Set("console", c); err != nil {
+		return err
+	}
+	return vm.Set(log2.Key, &scriptLogger{out: out})
+}
+
+// outputOf 获取 VM 的 scriptOutput
+func outputOf(vm *goja.Runtime) *scriptOutput {
+	out, _ := vm.GlobalObject().GetSymbol(outputSymbol).Export().(*scriptOutput)
+	return out
+}
+
+// console 返回 console 的方法, 参数格式与 node.js 的 util.format 一致
+func (o *scriptOutput) console(level string, print func(l *log2.Log, args ...any)) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		var b bytes.Buffer
+		var format string
+		if arg := call.Argument(0); !goja.IsUndefined(arg) {
+			format = arg.String()
+		}
+		var args []goja.Value
+		if len(call.Arguments) > 0 {
+			args = call.Arguments[1:]
+		}
+		o.util.Format(&b, format, args...)
+		message := b.String()
+		if capture := o.capture(); capture != nil {
+			values := make([]interface{}, len(call.Arguments))
+			for i, arg := range call.Arguments {
+				values[i] = arg.Export()
+			}
+			capture.add(LogEntry{Source: "console", Level: level, Time: time.Now(), Message: message, Args: values})
+		}
+		print(o.log(), message)
+		return goja.Undefined()
+	}
+}
+
+// log 返回输出使用的日志, 执行期间的日志携带执行的 ctx, 如 trace id
+func (o *scriptOutput) log() *log2.Log {
+	l := o.logger.Load()
+	if current := o.current.Load(); current != nil {
+		return l.WithContext(current.ctx)
+	}
+	return l
+}
+
+// capture 返回当前执行的 LogCapture, 未记录输出时返回 nil
+func (o *scriptOutput) capture() *LogCapture {
+	if current := o.current.Load(); current != nil {
+		return current.capture
+	}
+	return nil
+}
+
+// record 记录 logger 的输出
+func (o *scriptOutput) record(level string, args []any) {
+	capture := o.capture()
+	if capture == nil {
+		return
+	}
+	capture.add(LogEntry{
+		Source:  "logger",
+		Level:   level,
+		Time:    time.Now(),
+		Message: strings.TrimSuffix(fmt.Sprintln(args...), "\n"),
+		Args:    args,
+	})
+}
+
+// withContext 在 fn 执行期间 VM 的日志携带 ctx, capture 不为 nil 时将输出记录到 capture
+func (o *scriptOutput) withContext(ctx context.Context, capture *LogCapture, fn func() error) error {
+	if o == nil {
+		return fn()
+	}
+	o.current.Store(&invocation{ctx: ctx, capture: capture})
+	defer o.current.Store(nil)
+	return fn()
+}
+
+// scriptLogger 脚本中的 logger 对象, 输出到 log.Log 并记录到 LogCapture
+type scriptLogger struct {
+	out *scriptOutput
+}
+
+func (l *scriptLogger) Debug(args ...any) {
+	l.out.record("debug", args)
+	l.out.log().Debug(args...)
+}
+
+func (l *scriptLogger) Info(args ...any) {
+	l.out.record("info", args)
+	l.out.log().Info(args...)
+}
+
+func (l *scriptLogger) Warn(args ...any) {
+	l.out.record("warn", args)
+	l.out.log().Warn(args...)
+}
+
+func (l *scriptLogger) Error(args ...any) {
+	l.out.record("error", args)
+	l.out.log().Error(args...)
+}
@@ -0,0 +1,113 @@
+package gojs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/air-iot/gojs/log"
+)
+
+func TestRun_LogCapture(t *testing.T) {
+	js := `console.log("loading");
+function handler(data) {
+	console.log("value %d of %s", data.v, "sensor", true);
+	console.warn("warn");
+	logger.Info("info", data.v);
+	return new Promise(resolve => setTimeout(() => {
+		console.error({a: 1});
+		resolve(data.v);
+	}, 5));
+}`
+	ctx, capture := NewLogCaptureContext(context.Background())
+	val, err := RunByIdAndScriptWithContext(ctx, "log-capture", js, map[string]interface{}{"v": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 3 {
+		t.Fatalf("expected 3, got %v", val)
+	}
+	entries := capture.Entries()
+	want := []struct{ source, level, message string }{
+		{"console", "log", "value 3 of sensor true"},
+		{"console", "warn", "warn"},
+		{"logger", "info", "info 3"},
+		{"console", "error", "[object Object]"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), entries)
+	}
+	for i, w := range want {
+		if entries[i].Source != w.source || entries[i].Level != w.level || entries[i].Message != w.message {
+			t.Errorf("entry %d: expected %+v, got %+v", i, w, entries[i])
+		}
+		if entries[i].Time.IsZero() {
+			t.Errorf("entry %d: time not set", i)
+		}
+	}
+	if args := entries[0].Args; len(args) != 4 || args[3] != true {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	// 未指定 LogCapture 的执行不记录
+	if _, err := RunById("log-capture", map[string]interface{}{"v": 4}); err != nil {
+		t.Fatal(err)
+	}
+	if len(capture.Entries()) != len(want) {
+		t.Fatalf("expected no new entries, got %+v", capture.Entries())
+	}
+}
+
+func TestGetJsVm_LogOptions(t *testing.T) {
+	e, err := NewEngine(SetLogger(log.NewLogger(log.SetProject("p1"))), SetPoolSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	console.log("handler");
+	logger.Info("handler");
+	return 1;
+}`
+	jsVM, err := e.GetJsVm("log-options", js, log.SetKey("table1"), log.SetModule("driver"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := jsVM.logger.Load()
+	if logger == e.o.Logger {
+		t.Fatal("expected per-script logger")
+	}
+	if _, err := e.RunById("log-options"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不指定日志配置时沿用之前的日志, 脚本变化重新加载后也沿用
+	if jsVM, _ = e.GetJsVm("log-options", js); jsVM.logger.Load() != logger {
+		t.Fatal("expected logger to be kept")
+	}
+	jsVM, err = e.GetJsVm("log-options", js+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsVM.logger.Load() != logger {
+		t.Fatal("expected logger to be kept after reload")
+	}
+
+	// 池中的 VM 共享同一日志, 修改后立即生效
+	pooled, err := jsVM.pool.newVm(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetJsVm("log-options", js+"\n", log.SetKey("table2")); err != nil {
+		t.Fatal(err)
+	}
+	if pooled.output.logger.Load() == logger || pooled.output.logger.Load() != jsVM.logger.Load() {
+		t.Fatal("expected pooled vm to share the updated logger")
+	}
+
+	other, err := e.GetJsVm("log-other", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.logger.Load() != e.o.Logger {
+		t.Fatal("expected engine logger for scripts without log options")
+	}
+}
@@ -0,0 +1,216 @@
+package gojs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/dop251/goja"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stage 管道中的一个脚本
+type Stage struct {
+	// 脚本 id
+	ID string
+	// 脚本内容, 为空时使用已缓存的脚本, 同 RunById
+	Script string
+	// 执行的函数, 为空时执行入口函数
+	Function string
+}
+
+// PipelineError 管道中某个脚本执行失败, 通过 errors.As 获取, 原始错误可以继续通过 errors.As 获取 *ScriptError
+type PipelineError struct {
+	// 失败的脚本在管道中的序号, 从 0 开始
+	Stage int
+	// 失败的脚本 id
+	ID string
+	// 原始错误
+	Err error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline stage %d (%s) err,%s", e.Stage, e.ID, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline 依次执行多个脚本, 前一个脚本的返回值作为后一个脚本的唯一参数
+// 返回值在脚本的 VM 之间传递时, 其中的 Buffer 和 ArrayBuffer 在后一个脚本的 VM 中重新创建为 Buffer
+type Pipeline struct {
+	engine *Engine
+	stages []Stage
+}
+
+// NewPipeline 创建依次执行 stages 的管道
+func (e *Engine) NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{engine: e, stages: stages}
+}
+
+// Run 使用 values 执行第一个脚本, []byte 类型的参数以 Buffer 传入
+// 返回最后一个脚本的返回值, 中间的脚本返回 undefined 或 null 时结束管道并返回 null
+func (p *Pipeline) Run(values ...interface{}) (goja.Value, error) {
+	return p.RunWithContext(context.Background(), values...)
+}
+
+// RunWithContext 同 Run, ctx 超时或取消时中断正在执行的脚本
+func (p *Pipeline) RunWithContext(ctx context.Context, values ...interface{}) (_ goja.Value, err error) {
+	if len(p.stages) == 0 {
+		return nil, fmt.Errorf("pipeline has no stages")
+	}
+	ctx, span := p.engine.tracer.Start(ctx, "gojs.pipeline", trace.WithAttributes(
+		attrPipelineStages.Int(len(p.stages)),
+	))
+	defer func() { endSpan(span, err) }()
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		if bs, ok := v.([]byte); ok {
+			args[i] = transferValue{value: bs}
+		} else {
+			args[i] = v
+		}
+	}
+	last := len(p.stages) - 1
+	for i, stage := range p.stages {
+		jsVM, err := p.engine.stageJsVm(ctx, stage)
+		if err != nil {
+			return nil, stageError(i, stage.ID, err)
+		}
+		if i == last {
+			val, err := jsVM.call(ctx, stage.Function, args...)
+			if err != nil {
+				return nil, stageError(i, stage.ID, err)
+			}
+			return val, nil
+		}
+		output, err := jsVM.callExport(ctx, stage.Function, args...)
+		if err != nil {
+			return nil, stageError(i, stage.ID, err)
+		}
+		if output == nil {
+			return goja.Null(), nil
+		}
+		args = []interface{}{transferValue{value: output}}
+	}
+	return nil, nil
+}
+
+// stageError 包装管道中脚本的错误, 错误码为 100040020, 原始错误码通过 PipelineError.Err 获取
+func stageError(stage int, id string, err error) error {
+	return errors.Wrap400Response(&PipelineError{Stage: stage, ID: id, Err: err}, 100040020, "脚本管道第%d个脚本%s执行失败", stage, id)
+}
+
+// stageJsVm 获取管道中脚本的 VM, 脚本内容为空时使用已缓存的脚本
+func (e *Engine) stageJsVm(ctx context.Context, stage Stage) (*JSvm, error) {
+	if stage.Script == "" {
+		return e.cachedJsVm(stage.ID)
+	}
+	return e.getJsVm(ctx, stage.ID, stage.Script)
+}
+
+// callExport 同 call, 返回值在归还 VM 前转换为可以传递到其他 VM 的值, 返回 undefined 或 null 时为 nil
+func (j *JSvm) callExport(ctx context.Context, name string, values ...interface{}) (exported interface{}, err error) {
+	err = j.callLocked(ctx, name, values, func(output goja.Value) {
+		exported = exportTransfer(output)
+	})
+	return exported, err
+}
+
+// callLocked 同 call, 执行成功后在归还 VM 前以返回值调用 fn, 用于在持有 VM 的锁时读取返回的 js 对象
+func (j *JSvm) callLocked(ctx context.Context, name string, values []interface{}, fn func(output goja.Value)) error {
+	return j.observe(ctx, name, values, func(ctx context.Context) error {
+		vm, release, err := j.acquire(ctx, hasObject(values))
+		if err != nil {
+			return err
+		}
+		defer release()
+		output, err := j.apply(ctx, vm, name, values)
+		if err != nil {
+			return err
+		}
+		fn(output)
+		return nil
+	})
+}
+
+// transferObject 保留属性顺序的 js 对象
+type transferObject struct {
+	keys   []string
+	values []interface{}
+}
+
+// exportTransfer 将 js 值转换为可以传递到其他 VM 的值, 调用时需持有 VM 的锁
+// Buffer, Uint8Array 和 ArrayBuffer 转换为 []byte, 数组和普通对象递归转换, 对象保留属性顺序
+func exportTransfer(val goja.Value) interface{} {
+	if isNullish(val) {
+		return nil
+	}
+	if bs, ok := bytesOf(val); ok {
+		return append([]byte(nil), bs...)
+	}
+	obj, ok := val.(*goja.Object)
+	if !ok {
+		return val.Export()
+	}
+	if obj.ClassName() == "Array" {
+		list := make([]interface{}, obj.Get("length").ToInteger())
+		for i := range list {
+			list[i] = exportTransfer(obj.Get(strconv.Itoa(i)))
+		}
+		return list
+	}
+	if _, ok := isObject(obj); ok {
+		keys := obj.Keys()
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = exportTransfer(obj.Get(key))
+		}
+		return transferObject{keys: keys, values: values}
+	}
+	return obj.Export()
+}
+
+// transferValue 在脚本的 VM 之间传递的值, 执行时在目标 VM 中创建
+type transferValue struct {
+	value interface{}
+}
+
+// toValue 在 vm 中创建值, []byte 创建为 Buffer, 调用时需持有 vm 的锁
+func (t transferValue) toValue(vm *goja.Runtime) (goja.Value, error) {
+	switch v := t.value.(type) {
+	case nil:
+		return goja.Null(), nil
+	case []byte:
+		return BytesToBuffer(vm, v)
+	case time.Time:
+		return vm.New(vm.Get("Date"), vm.ToValue(v.UnixMilli()))
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			val, err := transferValue{value: item}.toValue(vm)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = val
+		}
+		return vm.NewArray(list...), nil
+	case transferObject:
+		obj := vm.NewObject()
+		for i, key := range v.keys {
+			val, err := transferValue{value: v.values[i]}.toValue(vm)
+			if err != nil {
+				return nil, err
+			}
+			if err := obj.Set(key, val); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	default:
+		return vm.ToValue(v), nil
+	}
+}
@@ -0,0 +1,88 @@
+package gojs
+
+import (
+	"testing"
+
+	"github.com/air-iot/errors"
+)
+
+func TestPipeline(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode := `function handler(frame) {
+	if (frame.length < 4) {
+		throw new Error("short frame");
+	}
+	return {raw: frame.slice(0, 2), temperature: frame.readInt16BE(0), humidity: frame.readUInt16BE(2)};
+}`
+	normalise := `function handler(data) {
+	return {raw: data.raw, hex: data.raw.toString("hex"), temperature: data.temperature / 10, humidity: data.humidity};
+}`
+	mapping := `function handler(data) {
+	if (data.humidity === 0) {
+		return null;
+	}
+	return [{id: tenant, values: data}];
+}`
+	if _, err := e.GetJsVm("mapping", mapping); err != nil {
+		t.Fatal(err)
+	}
+	p := e.NewPipeline(
+		Stage{ID: "decode", Script: decode},
+		Stage{ID: "normalise", Script: normalise},
+		Stage{ID: "mapping"},
+	)
+	if _, err := p.Run([]byte{0x00, 0xfa, 0x00, 0x3c}); err == nil {
+		t.Fatal("expected tenant to be undefined")
+	}
+	jsVM, err := e.GetJsVm("mapping", mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jsVM.SetObj("tenant", "dev1"); err != nil {
+		t.Fatal(err)
+	}
+	val, err := p.Run([]byte{0x00, 0xfa, 0x00, 0x3c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := NewParser().Parse(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := results[0].Values
+	if results[0].ID != "dev1" || values["temperature"] != int64(25) || values["humidity"] != int64(60) || values["hex"] != "00fa" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if raw, ok := values["raw"].([]byte); !ok || len(raw) != 2 || raw[1] != 0xfa {
+		t.Fatalf("expected raw to be transferred as Buffer, got %#v", values["raw"])
+	}
+
+	val, err = p.Run([]byte{0x00, 0xfa, 0x00, 0x00})
+	if err != nil || IsValid(val) {
+		t.Fatalf("expected null, got %v %v", val, err)
+	}
+
+	_, err = p.Run([]byte{0x00})
+	var pipelineErr *PipelineError
+	if !errors.As(err, &pipelineErr) || pipelineErr.Stage != 0 || pipelineErr.ID != "decode" {
+		t.Fatalf("expected decode stage error, got %v", err)
+	}
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Message != "short frame" {
+		t.Fatalf("expected script error, got %v", err)
+	}
+
+	_, err = e.NewPipeline(Stage{ID: "decode", Script: decode}, Stage{ID: "missing"}).Run([]byte{0, 1, 0, 1})
+	if !errors.As(err, &pipelineErr) || pipelineErr.Stage != 1 {
+		t.Fatalf("expected missing stage error, got %v", err)
+	}
+	if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040020 {
+		t.Fatalf("expected error code 100040020, got %v", err)
+	}
+	if resErr := errors.UnWrapResponse(pipelineErr.Err); resErr == nil || resErr.Code != 100040006 {
+		t.Fatalf("expected stage error code 100040006, got %v", pipelineErr.Err)
+	}
+}
@@ -0,0 +1,171 @@
+package gojs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+var PoolWaitError = errors.New400Response(100040014, "等待脚本VM超时")
+
+// vmPool 同一脚本的 VM 池
+// 池中的每个 VM 都加载了相同的脚本, vms[0] 为主 VM, 即 JSvm 本身, 不会被空闲回收
+type vmPool struct {
+	size        int
+	idleTimeout time.Duration
+	maxWait     time.Duration
+	newVm       func(ctx context.Context) (*JSvm, error)
+	// runs Engine 中正在进行的执行
+	runs *runGroup
+	// sem 限制同时执行的数量不超过池大小
+	sem chan struct{}
+
+	// primary 主 VM, 即 vms[0], 不会变化, 读取时不需要加锁
+	primary *JSvm
+	// done 关闭时关闭, 唤醒等待执行名额的调用
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	vms      []*JSvm
+	creating int
+}
+
+func newVmPool(o options, runs *runGroup, primary *JSvm, newVm func(ctx context.Context) (*JSvm, error)) *vmPool {
+	size := o.PoolSize
+	if size < 1 {
+		size = 1
+	}
+	return &vmPool{
+		size:        size,
+		idleTimeout: o.PoolIdleTimeout,
+		maxWait:     o.PoolMaxWait,
+		newVm:       newVm,
+		runs:        runs,
+		sem:         make(chan struct{}, size),
+		primary:     primary,
+		done:        make(chan struct{}),
+		vms:         []*JSvm{primary},
+	}
+}
+
+// acquire 从池中获取一个空闲的 VM, 返回的 VM 已加锁
+// primary 为 true 时只获取主 VM, 用于参数中包含主 VM 创建的 js 对象的情况
+func (p *vmPool) acquire(ctx context.Context, primary bool) (*JSvm, error) {
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	select {
+	case <-p.done:
+		<-p.sem
+		return nil, EngineClosedError
+	default:
+	}
+	// 已被替换或移出缓存的 VM 池不会被 stop, Engine 关闭后在这里拒绝执行
+	if !p.runs.add() {
+		<-p.sem
+		return nil, EngineClosedError
+	}
+	if primary {
+		p.primary.lock.Lock()
+		return p.primary, nil
+	}
+	p.mu.Lock()
+	for _, vm := range p.vms {
+		if vm.lock.TryLock() {
+			p.mu.Unlock()
+			return vm, nil
+		}
+	}
+	if len(p.vms)+p.creating < p.size {
+		p.creating++
+		p.mu.Unlock()
+		vm, err := p.newVm(ctx)
+		p.mu.Lock()
+		p.creating--
+		if err != nil {
+			p.mu.Unlock()
+			<-p.sem
+			p.runs.done()
+			return nil, err
+		}
+		vm.lock.Lock()
+		p.vms = append(p.vms, vm)
+		p.mu.Unlock()
+		return vm, nil
+	}
+	p.mu.Unlock()
+	p.primary.lock.Lock()
+	return p.primary, nil
+}
+
+// wait 等待执行名额, 超过最大等待时间时返回 PoolWaitError, ctx 结束时返回 ctx 的错误
+func (p *vmPool) wait(ctx context.Context) error {
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	default:
+	}
+	var timeout <-chan time.Time
+	if p.maxWait > 0 {
+		timer := time.NewTimer(p.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-timeout:
+		return PoolWaitError
+	case <-p.done:
+		return EngineClosedError
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release 归还 VM, 并回收空闲超时的 VM
+func (p *vmPool) release(vm *JSvm) {
+	vm.lastUsed = time.Now()
+	vm.lock.Unlock()
+	<-p.sem
+	p.evictIdle()
+	p.runs.done()
+}
+
+func (p *vmPool) evictIdle() {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vms := p.vms[:1]
+	for _, vm := range p.vms[1:] {
+		if vm.lock.TryLock() {
+			idle := time.Since(vm.lastUsed) > p.idleTimeout
+			vm.lock.Unlock()
+			if idle {
+				continue
+			}
+		}
+		vms = append(vms, vm)
+	}
+	for i := len(vms); i < len(p.vms); i++ {
+		p.vms[i] = nil
+	}
+	p.vms = vms
+}
+
+// stop 关闭 VM 池, 唤醒等待执行名额的调用, 之后的获取返回 EngineClosedError
+func (p *vmPool) stop() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+// len 返回池中 VM 的数量
+func (p *vmPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.vms)
+}
@@ -0,0 +1,109 @@
+package gojs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+)
+
+func TestPool_Concurrent(t *testing.T) {
+	e, err := NewEngine(SetPoolSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(i) {
+	apilib.SleepMill(200)
+	return i;
+}`
+	if _, err := e.RunByIdAndScript("pool", js, 0); err != nil {
+		t.Fatal(err)
+	}
+	run := func() time.Duration {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				val, err := e.RunByIdAndScript("pool", js, i)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if val.ToInteger() != int64(i) {
+					t.Errorf("expected %d, got %v", i, val)
+				}
+			}(i)
+		}
+		wg.Wait()
+		return time.Since(start)
+	}
+	// 第一次执行时创建池中的 VM
+	run()
+	if elapsed := run(); elapsed > 600*time.Millisecond {
+		t.Fatalf("expected concurrent execution, took %s", elapsed)
+	}
+	jsVM, err := e.GetJsVm("pool", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := jsVM.pool.len(); n != 4 {
+		t.Fatalf("expected 4 vms in pool, got %d", n)
+	}
+}
+
+func TestPool_MaxWait(t *testing.T) {
+	e, err := NewEngine(SetPoolMaxWait(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	apilib.SleepMill(300)
+	return 1;
+}`
+	if _, err := e.GetJsVm("wait", js); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = e.RunById("wait")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err = e.RunById("wait")
+	if !errors.Is(err, PoolWaitError) {
+		t.Fatalf("expected PoolWaitError, got %v", err)
+	}
+	<-done
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	e, err := NewEngine(SetPoolSize(2), SetPoolIdleTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	apilib.SleepMill(50)
+}`
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := e.RunByIdAndScript("idle", js); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := e.RunById("idle"); err != nil {
+		t.Fatal(err)
+	}
+	jsVM, _ := e.GetJsVm("idle", js)
+	if n := jsVM.pool.len(); n != 1 {
+		t.Fatalf("expected idle vm to be evicted, got %d vms", n)
+	}
+}
@@ -0,0 +1,137 @@
+package gojs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/dop251/goja"
+	"github.com/google/pprof/profile"
+)
+
+// ProfileRunningError 已有 CPU 采样在进行, goja 的采样器是进程级的, 同一时间只能有一个
+var ProfileRunningError = errors.New400Response(100040018, "CPU采样已在进行")
+
+// profileLock 保证同一时间只有一个 CPUProfile
+var profileLock sync.Mutex
+
+// CPUProfile 一次 CPU 采样, 通过 Engine.StartCPUProfile 开始, Stop 结束并输出 pprof 格式的结果
+// 采样结果中的函数为 js 函数, 行号为脚本中的行号, 文件为脚本 id, 内容相同的多个脚本以逗号分隔, 不在缓存中的脚本为脚本内容的 md5 加 .js
+// 采样的是 VM 执行指令的时间, 每 10ms 一次, 脚本调用阻塞的 Go 函数时等待的时间也会计入
+type CPUProfile struct {
+	engine *Engine
+	ids    []string
+	// files 脚本文件名对应的脚本 id, 包括开始和结束采样时缓存的脚本, 采样期间脚本变化时同时包括新旧脚本
+	files map[string][]string
+	buf   bytes.Buffer
+
+	once sync.Once
+	err  error
+}
+
+// StartCPUProfile 开始 CPU 采样, ids 为空时采样进程中所有 VM, 包括其他 Engine 的 VM
+// 指定 ids 时只保留调用栈中包含这些脚本函数的样本, 内容相同的脚本共享编译结果, 无法区分
+// goja 的采样器是进程级的, 已有采样在进行时返回 ProfileRunningError
+func (e *Engine) StartCPUProfile(ids ...string) (*CPUProfile, error) {
+	if !profileLock.TryLock() {
+		return nil, ProfileRunningError
+	}
+	p := &CPUProfile{engine: e, ids: ids, files: make(map[string][]string)}
+	p.addFiles()
+	if err := goja.StartProfile(&p.buf); err != nil {
+		profileLock.Unlock()
+		return nil, errors.Wrap400Response(err, 100040018, "CPU采样已在进行")
+	}
+	return p, nil
+}
+
+// ProfileCPU 采样 duration 时间或直到 ctx 结束, 将 pprof 格式的结果写入 w, ids 同 StartCPUProfile
+func (e *Engine) ProfileCPU(ctx context.Context, duration time.Duration, w io.Writer, ids ...string) error {
+	p, err := e.StartCPUProfile(ids...)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	return p.Stop(w)
+}
+
+// addFiles 记录缓存的脚本的文件名, 指定 ids 时只记录 ids 对应的脚本
+func (p *CPUProfile) addFiles() {
+	if len(p.ids) == 0 {
+		for _, jsVM := range p.engine.cache.values() {
+			p.addFile(jsVM.id, jsVM.Hash)
+		}
+		return
+	}
+	for _, id := range p.ids {
+		if jsVM := p.engine.peekJsVm(id); jsVM != nil {
+			p.addFile(id, jsVM.Hash)
+		}
+	}
+}
+
+func (p *CPUProfile) addFile(id, hash string) {
+	file := scriptFileName(hash)
+	for _, existing := range p.files[file] {
+		if existing == id {
+			return
+		}
+	}
+	p.files[file] = append(p.files[file], id)
+}
+
+// Stop 结束采样并将 pprof 格式的结果写入 w, 可以使用 go tool pprof 查看, 重复调用只写入一次
+func (p *CPUProfile) Stop(w io.Writer) error {
+	p.once.Do(func() {
+		goja.StopProfile()
+		profileLock.Unlock()
+		p.addFiles()
+		p.err = p.write(w)
+	})
+	return p.err
+}
+
+// write 过滤样本并将脚本文件名替换为脚本 id, 未指定 ids 时保留所有样本
+func (p *CPUProfile) write(w io.Writer) error {
+	pr, err := profile.Parse(&p.buf)
+	if err != nil {
+		return err
+	}
+	if len(p.ids) > 0 {
+		samples := pr.Sample[:0]
+		for _, sample := range pr.Sample {
+			if p.match(sample) {
+				samples = append(samples, sample)
+			}
+		}
+		pr.Sample = samples
+		pr = pr.Compact()
+	}
+	for _, f := range pr.Function {
+		if ids, ok := p.files[f.Filename]; ok {
+			f.Filename = strings.Join(ids, ",")
+		}
+	}
+	return pr.Write(w)
+}
+
+// match 样本的调用栈中是否包含指定脚本的函数
+func (p *CPUProfile) match(sample *profile.Sample) bool {
+	for _, loc := range sample.Location {
+		for _, line := range loc.Line {
+			if line.Function != nil && len(p.files[line.Function.Filename]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
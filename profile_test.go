@@ -0,0 +1,120 @@
+package gojs
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/google/pprof/profile"
+)
+
+func TestEngine_ProfileCPU(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot := `function hotLoop(n) {
+	let sum = 0;
+	for (let i = 0; i < n; i++) {
+		sum += Math.sqrt(i);
+	}
+	return sum;
+}
+function handler() {
+	return hotLoop(200000);
+}`
+	cold := `function coldLoop(n) {
+	let s = "";
+	for (let i = 0; i < n; i++) {
+		s = String(i);
+	}
+	return s;
+}
+function handler() {
+	return coldLoop(200000);
+}`
+	if _, err := e.GetJsVm("hot", hot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetJsVm("cold", cold); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, id := range []string{"hot", "cold"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if _, err := e.RunById(id); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(id)
+	}
+
+	var buf bytes.Buffer
+	err = e.ProfileCPU(context.Background(), 300*time.Millisecond, &buf, "hot")
+	cancel()
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Sample) == 0 {
+		t.Fatal("expected samples")
+	}
+	functions := make(map[string]string)
+	for _, f := range pr.Function {
+		functions[f.Name] = f.Filename
+	}
+	if functions["hotLoop"] != "hot" {
+		t.Fatalf("expected hotLoop in hot, got %v", functions)
+	}
+	if _, ok := functions["coldLoop"]; ok {
+		t.Fatalf("expected coldLoop to be filtered, got %v", functions)
+	}
+	for _, loc := range pr.Location {
+		for _, line := range loc.Line {
+			if line.Function.Name == "hotLoop" && (line.Line < 1 || line.Line > 7) {
+				t.Fatalf("unexpected hotLoop line %d", line.Line)
+			}
+		}
+	}
+}
+
+func TestStartCPUProfile_Running(t *testing.T) {
+	p, err := StartCPUProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = StartCPUProfile()
+	if resErr := errors.UnWrapResponse(err); resErr == nil || resErr.Code != 100040018 {
+		t.Fatalf("expected error code 100040018, got %v", err)
+	}
+	if _, err := Run(`function handler() { return 1; }`); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := p.Stop(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Stop(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := profile.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err = StartCPUProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Stop(&bytes.Buffer{})
+}
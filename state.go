@@ -0,0 +1,250 @@
+package gojs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/air-iot/errors"
+
+	"github.com/dop251/goja"
+)
+
+// StateStore 脚本全局变量 _state 的存储
+// 设置 SetStateStore 或通过 NewStateContext 指定 key 时, 执行脚本前按 key 加载 _state, 执行成功后保存
+// key 默认为脚本 id, 使用同一 key 的执行串行进行
+type StateStore interface {
+	// Load 加载 key 对应的 _state, 不存在时返回 nil, 返回的 map 会被脚本修改, 不能与存储中的数据共享
+	Load(key string) (map[string]interface{}, error)
+	// Save 保存 key 对应的 _state
+	Save(key string, state map[string]interface{}) error
+	// Delete 删除 key 对应的 _state, 不存在时不返回错误
+	Delete(key string) error
+}
+
+type stateKey struct{}
+
+// NewStateContext 指定执行脚本时 _state 的 key, 如设备 id
+func NewStateContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, stateKey{}, key)
+}
+
+// FromStateContext 获取 ctx 中指定的 _state 的 key
+func FromStateContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(stateKey{}).(string)
+	return key, ok && key != ""
+}
+
+// MemoryStateStore 内存中的 _state 存储, 进程重启后丢失
+type MemoryStateStore struct {
+	lock   sync.RWMutex
+	states map[string]map[string]interface{}
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[string]map[string]interface{})}
+}
+
+// Load 返回 key 对应的 _state 的副本
+func (s *MemoryStateStore) Load(key string) (map[string]interface{}, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyState(state), nil
+}
+
+// Save 保存 state 的副本, 之后对 state 的修改不影响存储
+func (s *MemoryStateStore) Save(key string, state map[string]interface{}) error {
+	state = copyState(state)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.states[key] = state
+	return nil
+}
+
+func (s *MemoryStateStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+// copyState 深拷贝 _state, 复制其中的对象, 数组和 []byte
+func copyState(state map[string]interface{}) map[string]interface{} {
+	if state == nil {
+		return nil
+	}
+	return copyStateValue(state).(map[string]interface{})
+}
+
+func copyStateValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = copyStateValue(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(val))
+		for i, item := range val {
+			list[i] = copyStateValue(item)
+		}
+		return list
+	case []byte:
+		return append([]byte(nil), val...)
+	default:
+		return v
+	}
+}
+
+// FileStateStore 文件中的 _state 存储, 每个 key 保存为目录下的一个 json 文件
+type FileStateStore struct {
+	dir    string
+	memory *MemoryStateStore
+}
+
+func NewFileStateStore(dir string) (*FileStateStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create state dir %s err,%s", dir, err)
+	}
+	return &FileStateStore{dir: dir, memory: NewMemoryStateStore()}, nil
+}
+
+func (s *FileStateStore) Load(key string) (map[string]interface{}, error) {
+	if state, _ := s.memory.Load(key); state != nil {
+		return state, nil
+	}
+	bs, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read state %s err,%s", key, err)
+	}
+	state := make(map[string]interface{})
+	if err := json.Unmarshal(bs, &state); err != nil {
+		return nil, fmt.Errorf("unmarshal state %s err,%s", key, err)
+	}
+	_ = s.memory.Save(key, state)
+	return state, nil
+}
+
+func (s *FileStateStore) Save(key string, state map[string]interface{}) error {
+	bs, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal state %s err,%s", key, err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".state-*")
+	if err != nil {
+		return fmt.Errorf("write state %s err,%s", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write state %s err,%s", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write state %s err,%s", key, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("write state %s err,%s", key, err)
+	}
+	return s.memory.Save(key, state)
+}
+
+func (s *FileStateStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete state %s err,%s", key, err)
+	}
+	return s.memory.Delete(key)
+}
+
+func (s *FileStateStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+// keyLocks 按 key 加锁, 保证使用同一 _state 的脚本串行执行
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	ref int
+}
+
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.ref++
+	k.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.ref--
+		if l.ref == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// withState 从存储中加载 _state 到 vm, fn 执行成功后保存 _state
+// 未设置存储时, 通过 NewStateContext 指定 key 的执行使用 Engine 的内存存储, 否则使用 VM 自身的 _state 且不加锁
+// 从存储中加载的 _state 只在本次执行中可见, 执行结束后恢复 VM 自身的 _state
+func (e *Engine) withState(ctx context.Context, id string, vm *goja.Runtime, fn func() error) error {
+	store := e.o.StateStore
+	key, ok := FromStateContext(ctx)
+	switch {
+	case store == nil && !ok:
+		return fn()
+	case store == nil:
+		store = e.states
+	case !ok:
+		key = id
+	}
+	unlock := e.stateLocks.lock(key)
+	defer unlock()
+	own := vm.Get("_state")
+	defer func() {
+		_ = vm.Set("_state", own)
+	}()
+	state, err := store.Load(key)
+	if err != nil {
+		return errors.Wrap400Response(err, 100040016, "加载_state失败")
+	}
+	if state == nil {
+		state = make(map[string]interface{})
+	}
+	if err := vm.Set("_state", state); err != nil {
+		return errors.Wrap400Err(err, 100040001)
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	// 脚本中可能对 _state 重新赋值
+	if newState, ok := vm.Get("_state").Export().(map[string]interface{}); ok {
+		state = newState
+	}
+	if err := store.Save(key, state); err != nil {
+		return errors.Wrap400Response(err, 100040017, "保存_state失败")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package gojs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStateStore_Memory(t *testing.T) {
+	e, err := NewEngine(SetCacheExpiration(50*time.Millisecond, 10*time.Millisecond), SetStateStore(NewMemoryStateStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(v) {
+	const delta = _state.last === undefined ? 0 : v - _state.last;
+	_state.last = v;
+	return delta;
+}`
+	if _, err := e.RunByIdAndScript("energy", js, 100); err != nil {
+		t.Fatal(err)
+	}
+	// 等待 VM 过期
+	time.Sleep(100 * time.Millisecond)
+	if _, err := e.RunById("energy", 0); err == nil {
+		t.Fatal("expected vm to be expired")
+	}
+	val, err := e.RunByIdAndScript("energy", js, 130)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 30 {
+		t.Fatalf("expected delta 30, got %v", val)
+	}
+
+	ctx := NewStateContext(context.Background(), "device1")
+	val, err = e.RunByIdWithContext(ctx, "energy", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 0 {
+		t.Fatalf("expected separate state for device1, got %v", val)
+	}
+}
+
+func TestStateStore_File(t *testing.T) {
+	dir := t.TempDir()
+	js := `function handler(v) {
+	_state.count = (_state.count || 0) + 1;
+	_state.values = (_state.values || []).concat([v]);
+	return _state.count;
+}`
+	store, err := NewFileStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(SetStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewStateContext(context.Background(), "dev/1")
+	if _, err := e.RunByIdAndScriptWithContext(ctx, "count", js, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟进程重启
+	store, err = NewFileStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = NewEngine(SetStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := e.RunByIdAndScriptWithContext(ctx, "count", js, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 2 {
+		t.Fatalf("expected count 2, got %v", val)
+	}
+	state, err := store.Load("dev/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(state)
+
+	if err := store.Delete("dev/1"); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := store.Load("dev/1"); err != nil || state != nil {
+		t.Fatalf("expected state deleted, got %v %v", state, err)
+	}
+}
+
+func TestStateStore_Disabled(t *testing.T) {
+	e, err := NewEngine(SetStateStore(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	_state.n = (_state.n || 0) + 1;
+	return _state.n;
+}`
+	if _, err := e.RunByIdAndScript("local", js); err != nil {
+		t.Fatal(err)
+	}
+	val, err := e.RunById("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 2 {
+		t.Fatalf("expected vm local state 2, got %v", val)
+	}
+}
+
+func TestStateStore_Context(t *testing.T) {
+	e, err := NewEngine(SetCacheExpiration(50*time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler() {
+	_state.n = (_state.n || 0) + 1;
+	return _state.n;
+}`
+	ctx := NewStateContext(context.Background(), "device1")
+	if _, err := e.RunByIdAndScriptWithContext(ctx, "ctx", js); err != nil {
+		t.Fatal(err)
+	}
+	// 等待 VM 过期, 指定 key 的 _state 保留
+	time.Sleep(100 * time.Millisecond)
+	val, err := e.RunByIdAndScriptWithContext(ctx, "ctx", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 2 {
+		t.Fatalf("expected count 2, got %v", val)
+	}
+}
+
+func TestStateStore_ContextIsolated(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(secret, fail) {
+	if (secret) {
+		_state.secret = secret;
+	} else {
+		_state.plain = (_state.plain || 0) + 1;
+	}
+	if (fail) {
+		throw new Error("bad frame");
+	}
+	return JSON.stringify(_state);
+}`
+	ctx := NewStateContext(context.Background(), "tenantA")
+	if _, err := e.RunByIdAndScriptWithContext(ctx, "isolated", js, "tenantA-secret", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.RunByIdAndScriptWithContext(ctx, "isolated", js, "tenantA-secret", true); err == nil {
+		t.Fatal("expected error")
+	}
+	// 未指定 key 的执行使用 VM 自身的 _state, 看不到其他 key 的 _state
+	for _, want := range []string{`{"plain":1}`, `{"plain":2}`} {
+		val, err := e.RunByIdAndScript("isolated", js, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.String() != want {
+			t.Fatalf("expected %s, got %s", want, val)
+		}
+	}
+}
+
+func TestStateStore_Rollback(t *testing.T) {
+	store := NewMemoryStateStore()
+	e, err := NewEngine(SetStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(fail) {
+	_state.count = (_state.count || 0) + 1;
+	_state.list = (_state.list || []).concat([_state.count]);
+	if (fail) {
+		throw new Error("bad frame");
+	}
+	return _state.count;
+}`
+	for _, fail := range []bool{false, false, true} {
+		_, err := e.RunByIdAndScript("rollback", js, fail)
+		if fail != (err != nil) {
+			t.Fatalf("unexpected err %v", err)
+		}
+	}
+	state, err := store.Load("rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["count"] != int64(2) || len(state["list"].([]interface{})) != 2 {
+		t.Fatalf("expected failed run not to be saved, got %v", state)
+	}
+	val, err := e.RunById("rollback", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.ToInteger() != 3 {
+		t.Fatalf("expected count 3, got %v", val)
+	}
+
+	if err := store.Delete("rollback"); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := store.Load("rollback"); state != nil {
+		t.Fatalf("expected state deleted, got %v", state)
+	}
+}
@@ -0,0 +1,66 @@
+package gojs
+
+import (
+	"reflect"
+
+	"github.com/dop251/goja"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName 创建 tracer 使用的名称
+const instrumentationName = "github.com/air-iot/gojs"
+
+// 链路追踪 span 的属性
+const (
+	attrScriptID       = attribute.Key("gojs.script.id")
+	attrScriptHash     = attribute.Key("gojs.script.hash")
+	attrScriptSize     = attribute.Key("gojs.script.size")
+	attrFunction       = attribute.Key("gojs.function")
+	attrArgsCount      = attribute.Key("gojs.args.count")
+	attrArgsSizes      = attribute.Key("gojs.args.sizes")
+	attrErrorCategory  = attribute.Key("gojs.error.category")
+	attrBatchSize      = attribute.Key("gojs.batch.size")
+	attrBatchErrors    = attribute.Key("gojs.batch.errors")
+	attrPipelineStages = attribute.Key("gojs.pipeline.stages")
+)
+
+// endSpan 结束 span, err 不为 nil 时记录错误和错误类别
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attrErrorCategory.String(string(errorCategory(err))))
+	}
+	span.End()
+}
+
+// argSizes 返回参数的大小, 字符串和字节数组为字节数, Go 的切片和 map 为长度, 其他为 0
+// js 对象为 0, 读取其属性可能执行 getter, 只能在持有 VM 的锁时进行, 而 argSizes 在获取 VM 前调用
+func argSizes(values []interface{}) []int64 {
+	sizes := make([]int64, len(values))
+	for i, v := range values {
+		switch val := v.(type) {
+		case string:
+			sizes[i] = int64(len(val))
+		case []byte:
+			sizes[i] = int64(len(val))
+		case *goja.Object:
+			// 不读取 js 对象的属性
+		case goja.Value:
+			if IsValid(val) {
+				sizes[i] = int64(len(val.String()))
+			}
+		case transferValue:
+			sizes[i] = argSizes([]interface{}{val.value})[0]
+		default:
+			rv := reflect.ValueOf(v)
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map:
+				sizes[i] = int64(rv.Len())
+			}
+		}
+	}
+	return sizes
+}
@@ -0,0 +1,140 @@
+package gojs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/dop251/goja"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestRun_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	// 脚本中日志使用的 ctx 的 trace id
+	var logTraceID trace.TraceID
+	e, err := NewEngine(SetTracerProvider(tp), SetGlobal("traceId", func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		if current := outputOf(vm).current.Load(); current != nil {
+			logTraceID = trace.SpanContextFromContext(current.ctx).TraceID()
+		}
+		return goja.Undefined()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `function handler(name, data) {
+	traceId();
+	if (!data) {
+		throw new Error("bad");
+	}
+	return name;
+}`
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if _, err := e.RunByIdAndScriptWithContext(ctx, "tracing", js, "abc", []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	traceID := parent.SpanContext().TraceID()
+
+	spans := exporter.GetSpans().Snapshots()
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		names[span.Name()] = span
+		if span.SpanContext().TraceID() != traceID {
+			t.Errorf("span %s has trace id %s, expected %s", span.Name(), span.SpanContext().TraceID(), traceID)
+		}
+	}
+	for _, name := range []string{"gojs.compile", "gojs.vm.create", "gojs.invoke", "parent"} {
+		if _, ok := names[name]; !ok {
+			t.Fatalf("missing span %s in %v", name, names)
+		}
+	}
+	invoke := names["gojs.invoke"]
+	if v, _ := spanAttr(invoke, attrScriptID); v.AsString() != "tracing" {
+		t.Errorf("unexpected script id %v", v.AsString())
+	}
+	if v, _ := spanAttr(invoke, attrScriptHash); v.AsString() != scriptHash(js) {
+		t.Errorf("unexpected script hash %v", v.AsString())
+	}
+	if v, _ := spanAttr(invoke, attrArgsSizes); len(v.AsInt64Slice()) != 2 || v.AsInt64Slice()[0] != 3 || v.AsInt64Slice()[1] != 2 {
+		t.Errorf("unexpected args sizes %v", v.AsInt64Slice())
+	}
+	if invoke.Status().Code == codes.Error {
+		t.Errorf("unexpected error status %v", invoke.Status())
+	}
+	if logTraceID != traceID {
+		t.Errorf("expected script log trace id %s, got %s", traceID, logTraceID)
+	}
+
+	exporter.Reset()
+	if _, err := e.RunByIdAndScriptWithContext(context.Background(), "tracing", js, "abc"); err == nil {
+		t.Fatal("expected error")
+	}
+	spans = exporter.GetSpans().Snapshots()
+	if len(spans) != 1 || spans[0].Name() != "gojs.invoke" {
+		t.Fatalf("expected only invoke span for cached script, got %d spans", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error || len(spans[0].Events()) == 0 {
+		t.Errorf("expected error status, got %v", spans[0].Status())
+	}
+	if v, _ := spanAttr(spans[0], attrErrorCategory); v.AsString() != string(CategoryRuntime) {
+		t.Errorf("unexpected error category %v", v.AsString())
+	}
+}
+
+func TestRun_TracingObjectArgs(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	e, err := NewEngine(SetTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := `let reads = 0;
+function make() {
+	return {get length() { return ++reads; }};
+}
+function handler(obj) {
+	return obj.length;
+}`
+	if err := e.Preload("args", js); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := e.RunFunction("args", "make")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 记录参数大小时不能在 VM 的锁外执行 getter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := e.RunById("args", obj); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, span := range exporter.GetSpans().Snapshots() {
+		if span.Name() != "gojs.invoke" || len(span.Attributes()) == 0 {
+			continue
+		}
+		if v, ok := spanAttr(span, attrArgsSizes); ok && len(v.AsInt64Slice()) == 1 && v.AsInt64Slice()[0] != 0 {
+			t.Fatalf("expected js object size 0, got %v", v.AsInt64Slice())
+		}
+	}
+}
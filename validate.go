@@ -0,0 +1,350 @@
+package gojs
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja/token"
+)
+
+// loopGlobals 事件循环提供的全局函数, GetVm 创建的 VM 中没有
+var loopGlobals = []string{"setTimeout", "setInterval", "setImmediate", "clearTimeout", "clearInterval", "clearImmediate"}
+
+// ValidationIssue 脚本检查发现的问题
+type ValidationIssue struct {
+	// 问题描述
+	Message string `json:"message"`
+	// 相关的标识符, 如未定义的全局变量名或缺少的函数名
+	Name string `json:"name,omitempty"`
+	// 行号, 从 1 开始, 0 表示未知
+	Line int `json:"line"`
+	// 列号, 从 1 开始, 0 表示未知
+	Column int `json:"column"`
+}
+
+// ValidationResult 脚本检查结果
+type ValidationResult struct {
+	// 错误, 有错误的脚本无法加载, 如语法错误和缺少入口函数
+	Errors []ValidationIssue `json:"errors,omitempty"`
+	// 警告, 脚本可以加载但执行时可能出错, 如引用了未定义的全局变量
+	Warnings []ValidationIssue `json:"warnings,omitempty"`
+}
+
+// Valid 脚本没有错误时返回 true
+func (r *ValidationResult) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Validate 检查脚本, 不执行脚本也不影响脚本缓存
+// 检查语法, 入口函数是否定义, 以及是否引用了 Engine 未提供的全局变量
+func (e *Engine) Validate(script string) *ValidationResult {
+	result := &ValidationResult{}
+	prg, err := parser.ParseFile(nil, "", script, 0)
+	if err != nil {
+		scriptErr := newScriptError(CategoryCompile, err)
+		result.Errors = append(result.Errors, ValidationIssue{
+			Message: scriptErr.Message,
+			Line:    scriptErr.Line,
+			Column:  scriptErr.Column,
+		})
+		return result
+	}
+	functions := topLevelFunctions(prg)
+	for _, name := range e.o.EntryPoints {
+		if !functions[name] {
+			result.Errors = append(result.Errors, ValidationIssue{
+				Message: fmt.Sprintf("脚本中未定义函数 %s", name),
+				Name:    name,
+			})
+		}
+	}
+	globals, err := e.knownGlobals()
+	if err != nil {
+		// 无法创建 VM 时不检查全局变量
+		return result
+	}
+	s := newScopeScanner()
+	s.scan(prg)
+	for _, ident := range s.references {
+		name := ident.Name.String()
+		if s.declared[name] || globals[name] {
+			continue
+		}
+		// 同一变量只提示一次
+		s.declared[name] = true
+		position := prg.File.Position(int(ident.Idx) - prg.File.Base())
+		result.Warnings = append(result.Warnings, ValidationIssue{
+			Message: fmt.Sprintf("%s 未定义", name),
+			Name:    name,
+			Line:    position.Line,
+			Column:  position.Column,
+		})
+	}
+	return result
+}
+
+// knownGlobals 返回 Engine 创建的 VM 中的全局变量, 只计算一次
+func (e *Engine) knownGlobals() (map[string]bool, error) {
+	e.globalsOnce.Do(func() {
+		vm, err := e.GetVm()
+		if err != nil {
+			e.globalsErr = err
+			return
+		}
+		// 内置库的全局变量是访问器属性, 只获取属性名不会加载库
+		value, err := vm.RunString("Object.getOwnPropertyNames(globalThis)")
+		if err != nil {
+			e.globalsErr = err
+			return
+		}
+		var names []string
+		if err := vm.ExportTo(value, &names); err != nil {
+			e.globalsErr = err
+			return
+		}
+		globals := make(map[string]bool, len(names)+len(loopGlobals)+2)
+		for _, name := range names {
+			globals[name] = true
+		}
+		for _, name := range loopGlobals {
+			globals[name] = true
+		}
+		globals["require"] = true
+		globals["arguments"] = true
+		e.globals = globals
+		value, err = vm.RunString(`Object.getOwnPropertyNames(globalThis).filter(function (name) {
+	return Object.getOwnPropertyDescriptor(globalThis, name).writable === false;
+})`)
+		if err != nil {
+			e.globalsErr = err
+			return
+		}
+		if err := vm.ExportTo(value, &names); err != nil {
+			e.globalsErr = err
+			return
+		}
+		e.readonlyGlobals = make(map[string]bool, len(names))
+		for _, name := range names {
+			e.readonlyGlobals[name] = true
+		}
+	})
+	return e.globals, e.globalsErr
+}
+
+// readonlyGlobal 返回 name 是否为 VM 中只读的全局变量, 如 undefined 和 NaN
+func (e *Engine) readonlyGlobal(name string) bool {
+	if _, err := e.knownGlobals(); err != nil {
+		return false
+	}
+	return e.readonlyGlobals[name]
+}
+
+// topLevelConstants 返回脚本顶层 const 声明的变量名, 包括解构赋值中的变量
+func topLevelConstants(prg *ast.Program) map[string]bool {
+	constants := make(map[string]bool)
+	var add func(target ast.Expression)
+	add = func(target ast.Expression) {
+		switch t := target.(type) {
+		case *ast.Identifier:
+			constants[t.Name.String()] = true
+		case *ast.AssignExpression:
+			add(t.Left)
+		case *ast.ArrayPattern:
+			for _, elem := range t.Elements {
+				add(elem)
+			}
+			add(t.Rest)
+		case *ast.ObjectPattern:
+			for _, prop := range t.Properties {
+				switch p := prop.(type) {
+				case *ast.PropertyShort:
+					constants[p.Name.Name.String()] = true
+				case *ast.PropertyKeyed:
+					add(p.Value)
+				}
+			}
+			add(t.Rest)
+		}
+	}
+	for _, stmt := range prg.Body {
+		if s, ok := stmt.(*ast.LexicalDeclaration); ok && s.Token == token.CONST {
+			for _, binding := range s.List {
+				add(binding.Target)
+			}
+		}
+	}
+	return constants
+}
+
+// topLevelFunctions 返回脚本顶层定义的函数名
+// 包括函数声明和以函数表达式初始化的变量
+func topLevelFunctions(prg *ast.Program) map[string]bool {
+	functions := make(map[string]bool)
+	addBindings := func(list []*ast.Binding) {
+		for _, binding := range list {
+			ident, ok := binding.Target.(*ast.Identifier)
+			if !ok {
+				continue
+			}
+			switch binding.Initializer.(type) {
+			case *ast.FunctionLiteral, *ast.ArrowFunctionLiteral:
+				functions[ident.Name.String()] = true
+			}
+		}
+	}
+	for _, stmt := range prg.Body {
+		switch s := stmt.(type) {
+		case *ast.FunctionDeclaration:
+			if s.Function.Name != nil {
+				functions[s.Function.Name.Name.String()] = true
+			}
+		case *ast.VariableStatement:
+			addBindings(s.List)
+		case *ast.LexicalDeclaration:
+			addBindings(s.List)
+		}
+	}
+	return functions
+}
+
+// scopeScanner 收集脚本中声明的变量和引用的标识符
+// 不区分作用域, 任意位置声明的变量都视为已定义, 避免误报
+type scopeScanner struct {
+	declared   map[string]bool
+	references []*ast.Identifier
+	// skip 不作为变量引用的标识符, 如函数名, 标签名和 typeof 的操作数
+	skip map[*ast.Identifier]bool
+}
+
+func newScopeScanner() *scopeScanner {
+	return &scopeScanner{
+		declared: make(map[string]bool),
+		skip:     make(map[*ast.Identifier]bool),
+	}
+}
+
+var identifierType = reflect.TypeOf((*ast.Identifier)(nil))
+
+func (s *scopeScanner) scan(prg *ast.Program) {
+	s.walk(reflect.ValueOf(prg))
+}
+
+// walk 先序遍历语法树, 父节点先于子节点处理
+func (s *scopeScanner) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			s.walk(v.Elem())
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Type() == identifierType {
+			ident := v.Interface().(*ast.Identifier)
+			if !s.skip[ident] {
+				s.references = append(s.references, ident)
+			}
+			return
+		}
+		if node, ok := v.Interface().(ast.Node); ok {
+			s.visit(node)
+		}
+		s.walk(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				s.walk(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			s.walk(v.Index(i))
+		}
+	}
+}
+
+func (s *scopeScanner) visit(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.FunctionLiteral:
+		s.declareIdent(n.Name)
+		if n.ParameterList != nil {
+			s.declareParameters(n.ParameterList)
+		}
+	case *ast.ArrowFunctionLiteral:
+		if n.ParameterList != nil {
+			s.declareParameters(n.ParameterList)
+		}
+	case *ast.ClassLiteral:
+		s.declareIdent(n.Name)
+	case *ast.Binding:
+		s.declareTarget(n.Target)
+	case *ast.ForDeclaration:
+		s.declareTarget(n.Target)
+	case *ast.CatchStatement:
+		s.declareTarget(n.Parameter)
+	case *ast.AssignExpression:
+		// 非严格模式下对未声明的变量赋值会创建全局变量
+		if ident, ok := n.Left.(*ast.Identifier); ok && n.Operator == token.ASSIGN {
+			s.declared[ident.Name.String()] = true
+		}
+	case *ast.UnaryExpression:
+		if ident, ok := n.Operand.(*ast.Identifier); ok && n.Operator == token.TYPEOF {
+			s.skip[ident] = true
+		}
+	case *ast.LabelledStatement:
+		s.skip[n.Label] = true
+	case *ast.BranchStatement:
+		if n.Label != nil {
+			s.skip[n.Label] = true
+		}
+	case *ast.MetaProperty:
+		s.skip[n.Meta] = true
+		s.skip[n.Property] = true
+	}
+}
+
+func (s *scopeScanner) declareIdent(ident *ast.Identifier) {
+	if ident == nil {
+		return
+	}
+	s.declared[ident.Name.String()] = true
+	s.skip[ident] = true
+}
+
+func (s *scopeScanner) declareParameters(params *ast.ParameterList) {
+	for _, binding := range params.List {
+		s.declareTarget(binding.Target)
+	}
+	s.declareTarget(params.Rest)
+}
+
+// declareTarget 声明变量和解构赋值中的变量
+func (s *scopeScanner) declareTarget(target ast.Node) {
+	switch t := target.(type) {
+	case *ast.Identifier:
+		s.declareIdent(t)
+	case *ast.ObjectPattern:
+		for _, prop := range t.Properties {
+			switch p := prop.(type) {
+			case *ast.PropertyShort:
+				s.declared[p.Name.Name.String()] = true
+			case *ast.PropertyKeyed:
+				s.declareTarget(p.Value)
+			}
+		}
+		s.declareTarget(t.Rest)
+	case *ast.ArrayPattern:
+		for _, element := range t.Elements {
+			s.declareTarget(element)
+		}
+		s.declareTarget(t.Rest)
+	case *ast.AssignExpression:
+		// 带默认值的解构
+		s.declareTarget(t.Left)
+	case *ast.SpreadElement:
+		s.declareTarget(t.Expression)
+	}
+}
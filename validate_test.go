@@ -0,0 +1,65 @@
+package gojs
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	js := `var total = 0;
+function handler(data, { scale = 1 } = {}) {
+	const [first, ...rest] = data;
+	for (let i = 0; i < rest.length; i++) {
+		total += rest[i] * scale;
+	}
+	try {
+		JSON.parse("{");
+	} catch (e) {
+		console.log(e.message);
+	}
+	if (typeof optional === "undefined") {
+		total = 0;
+	}
+	setTimeout(() => {}, 0);
+	return CryptoJs.MD5(String(first + total)).toString() + _.join([1], "") + moment().year();
+}`
+	result := Validate(js)
+	if !result.Valid() {
+		t.Fatalf("expected valid, got %+v", result.Errors)
+	}
+	if len(result.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %+v", result.Warnings)
+	}
+	if w := result.Warnings[0]; w.Name != "CryptoJs" || w.Line != 16 || w.Column != 9 {
+		t.Fatalf("unexpected warning %+v", w)
+	}
+	if _, ok := defaultEngine.cache.peek(scriptHash(js)); ok {
+		t.Fatal("validate should not cache the script")
+	}
+	if _, ok := defaultEngine.scripts.peek(scriptHash(js)); ok {
+		t.Fatal("validate should not compile the script")
+	}
+}
+
+func TestValidate_Errors(t *testing.T) {
+	result := Validate(`function handler() {
+	return 1 +;
+}`)
+	if result.Valid() || result.Errors[0].Line != 2 || result.Errors[0].Column == 0 {
+		t.Fatalf("expected syntax error at line 2, got %+v", result.Errors)
+	}
+
+	result = Validate(`function handle() {}`)
+	if result.Valid() || result.Errors[0].Name != "handler" {
+		t.Fatalf("expected missing handler, got %+v", result.Errors)
+	}
+
+	e, err := NewEngine(SetEntryPoints("handler", "decode"), SetGlobal("device", map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = e.Validate(`const handler = async () => device.id;
+let decode = function() {};`)
+	if !result.Valid() || len(result.Warnings) != 0 {
+		t.Fatalf("expected valid without warnings, got %+v", result)
+	}
+}
@@ -1,230 +1,393 @@
 package gojs
 
 import (
-	"crypto/md5"
+	"context"
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/air-iot/errors"
-	"github.com/air-iot/gojs/api"
 	log2 "github.com/air-iot/gojs/log"
 
 	"github.com/dop251/goja"
-	"github.com/dop251/goja_nodejs/console"
-	"github.com/dop251/goja_nodejs/require"
-	"github.com/patrickmn/go-cache"
+	"github.com/dop251/goja_nodejs/eventloop"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var localCache = cache.New(5*time.Minute, 10*time.Minute)
-var registry *require.Registry
-var programs []*goja.Program
-var apilib *api.Lib
-var logLib *log2.Log
+// defaultEngine 包级函数使用的默认脚本执行引擎
+var defaultEngine *Engine
 
 type Callback func(vm *goja.Runtime) error
 
 func init() {
-	registry = require.NewRegistry()
-	programs = make([]*goja.Program, 0)
-	initPackages("packages/bcd.js")
-	initPackages("packages/buffer.js")
-	initPackages("packages/lodash.js")
-	initPackages("packages/crypto-js.js")
-	initPackages("packages/moment.js")
-	initPackages("packages/xml-js.js")
-	initPackages("packages/formulajs.js")
-	initPackages("packages/iconv-lite.js")
-	initPackages("packages/forge.js")
-	initPackages("packages/pako.min.js")
-	//initPackages("packages/uuid.js")
-	apilib = api.NewLib()
-	logLib = log2.NewLogger()
-}
-
-func initPackages(packagePath string) {
-	lodashBytes, err := F.ReadFile(packagePath)
+	e, err := NewEngine()
 	if err != nil {
-		panic(fmt.Errorf("read %s err,%s", packagePath, err))
+		panic(err)
 	}
-	p, err := goja.Compile(packagePath, string(lodashBytes), false)
-	if err != nil {
-		panic(fmt.Errorf("compile %s err,%s", packagePath, err))
+	defaultEngine = e
+}
+
+// DefaultEngine 返回包级函数使用的默认脚本执行引擎
+func DefaultEngine() *Engine {
+	return defaultEngine
+}
+
+var HandlerError = functionNotFoundError("handler")
+
+func functionNotFound(name string) error {
+	if name == "handler" {
+		return HandlerError
 	}
-	programs = append(programs, p)
+	return functionNotFoundError(name)
 }
 
-var HandlerError = errors.New400Response(100040004, "脚本函数handler未找到")
+func functionNotFoundError(name string) error {
+	scriptErr := &ScriptError{
+		Category: CategoryHandlerMissing,
+		Message:  fmt.Sprintf("%s is not a function", name),
+	}
+	return errors.Wrap400Response(scriptErr, 100040004, "脚本函数%s未找到", name)
+}
 
 type JSvm struct {
 	lock    sync.Mutex
 	VM      *goja.Runtime
 	Handler goja.Callable
-	Script  string
+	// Functions 脚本中定义的入口函数
+	Functions map[string]goja.Callable
+	Script    string
+	// Hash 脚本内容的 md5
+	Hash string
+
+	id       string
+	engine   *Engine
+	loop     *eventloop.EventLoop
+	pool     *vmPool
+	lastUsed time.Time
+	// output VM 中 console 和 logger 的输出
+	output *scriptOutput
+	// logger 脚本的日志, VM 池中的 VM 共享
+	logger *loggerRef
+	// source VM 的时间和随机数来源
+	source *runtimeSource
+	// objects SetObj 设置的对象, VM 池中的 VM 共享, objectsVersion 为该 VM 已设置的版本
+	objects        *vmObjects
+	objectsVersion uint64
+	// constants 脚本顶层的 const 变量名
+	constants map[string]bool
+	// runs 和 usedAt 为执行次数和最后执行时间, 只记录在缓存的 VM 上
+	runs   atomic.Int64
+	usedAt atomic.Int64
 }
 
-func NewJsVm(id, script string) (*JSvm, error) {
-	jsVM, err := GetJsVm(id, script)
-	if err != nil {
-		return nil, err
-	}
-	return jsVM, nil
+func NewJsVm(id, script string, logOpts ...log2.Option) (*JSvm, error) {
+	return defaultEngine.NewJsVm(id, script, logOpts...)
 }
 
+// SetObj 设置脚本 VM 的全局对象, 同时对 VM 池中的其他 VM 生效, 脚本内容变化重新加载后仍然保留
+// 对象在每个 VM 下一次执行前设置, 不等待正在进行的执行, 可以在脚本调用的 Go 函数中调用
+// key 为脚本顶层的 const 变量或只读的全局变量时返回错误, 执行前设置失败的对象记录日志后丢弃
 func (j *JSvm) SetObj(key string, obj interface{}) error {
-	if err := j.VM.Set(key, obj); err != nil {
-		return errors.Wrap400Err(err, 100040001)
+	if j.constants[key] || j.engine != nil && j.engine.readonlyGlobal(key) {
+		return errors.Wrap400Err(fmt.Errorf("全局变量 %s 不能赋值", key), 100040001)
 	}
+	j.objects.set(key, obj)
 	return nil
 }
 
-func GetVm() (*goja.Runtime, error) {
-	vm := goja.New()
-	//registry := new(require.Registry) // this can be shared by multiple runtimes
-	registry.Enable(vm)
-	console.Enable(vm)
-	obj := vm.GlobalObject()
-	state := map[string]interface{}{}
-	if err := obj.Set("_state", state); err != nil {
-		return nil, errors.Wrap400Err(err, 100040001)
-	}
-	for _, program := range programs {
-		_, err := vm.RunProgram(program)
-		if err != nil {
-			return nil, errors.Wrap400Err(err, 100040002)
-		}
+// vmObjects 通过 SetObj 设置的全局对象, 同一脚本的 VM 共享
+// 每个 VM 记录已设置的版本, 执行前设置新增的对象
+type vmObjects struct {
+	mu      sync.Mutex
+	version uint64
+	keys    []string
+	values  map[string]interface{}
+}
+
+func (o *vmObjects) set(key string, value interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.values == nil {
+		o.values = make(map[string]interface{})
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
 	}
+	o.values[key] = value
+	o.version++
+}
 
-	bufferObj := vm.Get("Buffer").(*goja.Object).Get("Buffer").(*goja.Object)
-	bufferPrototype := bufferObj.Get("prototype").(*goja.Object)
-	_ = bufferPrototype.Set("readBigInt64LE", readBigInt64LE(vm))
-	_ = bufferPrototype.Set("readBigInt64BE", readBigInt64BE(vm))
-	_ = bufferPrototype.Set("writeBigInt64LE", writeBigInt64LE(vm))
-	_ = bufferPrototype.Set("writeBigInt64BE", writeBigInt64BE(vm))
+// apply 在 vm 中设置所有对象, vm 已是最新版本时直接返回, 调用时需持有 vm 的锁
+// 设置失败的对象记录日志后删除, 不影响之后的执行
+func (o *vmObjects) apply(vm *JSvm) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if vm.objectsVersion == o.version {
+		return
+	}
+	keys := o.keys[:0]
+	for _, key := range o.keys {
+		if err := vm.VM.Set(key, o.values[key]); err != nil {
+			vm.logger.Load().Error("设置全局对象 ", key, " 失败: ", err)
+			delete(o.values, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	o.keys = keys
+	vm.objectsVersion = o.version
+}
 
-	_ = bufferPrototype.Set("readBigUInt64LE", readBigUInt64LE(vm))
-	_ = bufferPrototype.Set("readBigUInt64BE", readBigUInt64BE(vm))
-	_ = bufferPrototype.Set("writeBigUInt64LE", writeBigUInt64LE(vm))
-	_ = bufferPrototype.Set("writeBigUInt64BE", writeBigUInt64BE(vm))
+// function 获取脚本中名称为 name 的函数, name 为空时返回入口函数
+func (j *JSvm) function(name string) (goja.Callable, error) {
+	if name == "" {
+		return j.Handler, nil
+	}
+	if fn, ok := j.Functions[name]; ok {
+		return fn, nil
+	}
+	fn, ok := goja.AssertFunction(j.VM.Get(name))
+	if !ok {
+		return nil, functionNotFound(name)
+	}
+	return fn, nil
+}
 
-	_ = vm.Set("_", vm.Get("lodash"))
-	_ = vm.Set("CryptoJS", vm.Get("cryptoJs"))
-	_ = vm.Set("Buffer", bufferObj)
-	_ = vm.Set("formulajs", vm.Get("formulajsformulajs"))
-	_ = vm.Set("iconv", vm.Get("iconvLite"))
-	_ = vm.Set("apilib", apilib)
-	_ = vm.Set(log2.Key, logLib)
-	_ = AttachCrc(vm)
-	return vm, nil
+// call 执行名称为 name 的函数, name 为空时执行入口函数, 并记录执行指标和链路追踪
+func (j *JSvm) call(ctx context.Context, name string, values ...interface{}) (goja.Value, error) {
+	var val goja.Value
+	err := j.observe(ctx, name, values, func(ctx context.Context) (err error) {
+		val, err = j.invoke(ctx, name, values...)
+		return err
+	})
+	return val, err
 }
 
-func GetVmCallback(cb Callback) (*goja.Runtime, error) {
-	vm, err := GetVm()
-	if err != nil {
-		return nil, err
+// observe 为一次函数执行 fn 记录执行指标和链路追踪
+func (j *JSvm) observe(ctx context.Context, name string, values []interface{}, fn func(ctx context.Context) error) error {
+	if j.engine == nil {
+		return fn(ctx)
 	}
-	_ = AttachCrc(vm)
-	if cb != nil {
-		if err := cb(vm); err != nil {
-			return nil, err
-		}
+	if err := j.engine.breakers.allow(j.id, j.Hash); err != nil {
+		return err
+	}
+	fnName := name
+	if fnName == "" {
+		fnName = j.engine.o.EntryPoints[0]
+	}
+	ctx, span := j.engine.tracer.Start(ctx, "gojs.invoke", trace.WithAttributes(
+		attrScriptID.String(j.id),
+		attrScriptHash.String(j.Hash),
+		attrFunction.String(fnName),
+		attrArgsCount.Int(len(values)),
+	))
+	if span.IsRecording() {
+		span.SetAttributes(attrArgsSizes.Int64Slice(argSizes(values)))
 	}
-	return vm, nil
+	start := time.Now()
+	err := fn(ctx)
+	j.engine.breakers.record(j.id, j.Hash, err)
+	j.observeRun(start, err)
+	endSpan(span, err)
+	return err
 }
 
-func GetJsVm(id, script string) (*JSvm, error) {
-	jsVMI, ok := localCache.Get(id)
-	var jsVM *JSvm
-	if !ok {
-		vm, err := GetVm()
-		if err != nil {
-			return nil, err
-		}
-		if _, err := vm.RunString(script); err != nil {
-			return nil, errors.Wrap400Err(err, 100040003)
-		}
-		handler, ok := goja.AssertFunction(vm.Get("handler"))
-		if !ok {
-			return nil, HandlerError
-		}
-		jsVM = &JSvm{
-			VM:      vm,
-			Handler: handler,
-			Script:  script,
-		}
-	} else {
-		jsVM, _ = jsVMI.(*JSvm)
-		if fmt.Sprintf("%x", md5.Sum([]byte(script))) != fmt.Sprintf("%x", md5.Sum([]byte(jsVM.Script))) {
-			if _, err := jsVM.VM.RunString(script); err != nil {
-				return nil, errors.Wrap400Err(err, 100040003)
-			}
-			handler, ok := goja.AssertFunction(jsVM.VM.Get("handler"))
-			if !ok {
-				return nil, HandlerError
-			}
-			jsVM.Script = script
-			jsVM.Handler = handler
-		}
+// invoke 从 VM 池中获取 VM 执行名称为 name 的函数
+func (j *JSvm) invoke(ctx context.Context, name string, values ...interface{}) (goja.Value, error) {
+	vm, release, err := j.acquire(ctx, hasObject(values))
+	if err != nil {
+		return nil, err
 	}
-	localCache.Set(id, jsVM, cache.DefaultExpiration)
-	return jsVM, nil
+	defer release()
+	return j.apply(ctx, vm, name, values)
 }
 
-func Run(script string, values ...interface{}) (goja.Value, error) {
-	id := fmt.Sprintf("%x", md5.Sum([]byte(script)))
-	return RunByIdAndScript(id, script, values...)
+// acquire 从 VM 池中获取 VM, 使用完后调用 release 归还
+// 参数中包含 js 对象时只能在创建该对象的主 VM 中执行, primary 为 true 时只获取主 VM
+func (j *JSvm) acquire(ctx context.Context, primary bool) (vm *JSvm, release func(), err error) {
+	if j.pool == nil {
+		j.lock.Lock()
+		return j, j.lock.Unlock, nil
+	}
+	vm, err = j.pool.acquire(ctx, primary)
+	if err != nil {
+		return nil, nil, wrapRunErr(ctx, err, 100040005)
+	}
+	return vm, func() { j.pool.release(vm) }, nil
 }
 
-func RunByIdAndScript(id, script string, values ...interface{}) (goja.Value, error) {
-	jsVM, err := GetJsVm(id, script)
+// apply 在已获取的 vm 中执行名称为 name 的函数
+func (j *JSvm) apply(ctx context.Context, vm *JSvm, name string, values []interface{}) (goja.Value, error) {
+	vm.objects.apply(vm)
+	fn, err := vm.function(name)
 	if err != nil {
 		return nil, err
 	}
 	vals := make([]goja.Value, len(values))
 	if values != nil {
 		for i, v := range values {
-			gojaVal, ok := v.(goja.Value)
-			if ok {
-				vals[i] = gojaVal
-			} else {
-				vals[i] = jsVM.VM.ToValue(v)
+			switch val := v.(type) {
+			case goja.Value:
+				vals[i] = val
+			case transferValue:
+				if vals[i], err = val.toValue(vm.VM); err != nil {
+					return nil, err
+				}
+			default:
+				vals[i] = vm.VM.ToValue(v)
 			}
 		}
 	}
-	jsVM.lock.Lock()
-	defer jsVM.lock.Unlock()
-	output, err := jsVM.Handler(goja.Undefined(), vals...)
+	var output goja.Value
+	run := func() error {
+		capture, _ := FromLogCaptureContext(ctx)
+		vm.source.withContext(ctx)
+		return vm.output.withContext(ctx, capture, func() error {
+			var err error
+			output, err = runOnLoop(ctx, vm.loop, vm.VM, func() (goja.Value, error) {
+				return fn(goja.Undefined(), vals...)
+			})
+			return err
+		})
+	}
+	if j.engine != nil {
+		err = j.engine.withState(ctx, j.id, vm.VM, run)
+	} else {
+		err = run()
+	}
 	if err != nil {
-		return nil, errors.Wrap400Err(err, 100040005)
+		return nil, wrapRunErr(ctx, err, 100040005)
 	}
 	return output, nil
 }
 
-func RunById(id string, values ...interface{}) (goja.Value, error) {
-	jsVMI, ok := localCache.Get(id)
-	if !ok {
-		return nil, errors.New400Response(100040006, "未找到vm")
+// runWithContext 执行 fn, ctx 超时或取消时中断 VM 的执行
+// 返回前清除中断标记, 保证缓存的 VM 可以继续使用
+// loop 不为 nil 时同时停止事件循环
+func runWithContext(ctx context.Context, vm *goja.Runtime, loop *eventloop.EventLoop, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
 	}
-	jsVM, _ := jsVMI.(*JSvm)
-	localCache.Set(id, jsVM, cache.DefaultExpiration)
-	vals := make([]goja.Value, len(values))
-	if values != nil {
-		for i, v := range values {
-			gojaVal, ok := v.(goja.Value)
-			if ok {
-				vals[i] = gojaVal
-			} else {
-				vals[i] = jsVM.VM.ToValue(v)
-			}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		vm.Interrupt(ctx.Err())
+		if loop != nil {
+			loop.StopNoWait()
 		}
+		close(interrupted)
+	})
+	err := fn()
+	if !stop() {
+		<-interrupted
+		vm.ClearInterrupt()
 	}
-	jsVM.lock.Lock()
-	defer jsVM.lock.Unlock()
-	output, err := jsVM.Handler(goja.Undefined(), vals...)
-	if err != nil {
-		return nil, errors.Wrap400Err(err, 100040005)
+	return err
+}
+
+// wrapRunErr 包装脚本执行错误, ctx 超时或取消导致的错误使用错误码 100040015
+func wrapRunErr(ctx context.Context, err error, code int) error {
+	if ctx.Err() != nil {
+		var interruptedErr *goja.InterruptedError
+		if errors.As(err, &interruptedErr) || errors.Is(err, ctx.Err()) {
+			return errors.Wrap400Response(newScriptError(CategoryTimeout, err), 100040015, "脚本执行超时")
+		}
 	}
-	return output, nil
+	var resErr *errors.ResponseError
+	if errors.As(err, &resErr) {
+		return err
+	}
+	return errors.Wrap400Err(newScriptError(CategoryRuntime, err), code)
+}
+
+func hasObject(values []interface{}) bool {
+	for _, v := range values {
+		if _, ok := v.(*goja.Object); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func GetVm() (*goja.Runtime, error) {
+	return defaultEngine.GetVm()
+}
+
+func GetVmCallback(cb Callback) (*goja.Runtime, error) {
+	return defaultEngine.GetVmCallback(cb)
+}
+
+func GetJsVm(id, script string, logOpts ...log2.Option) (*JSvm, error) {
+	return defaultEngine.GetJsVm(id, script, logOpts...)
+}
+
+func Run(script string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.Run(script, values...)
+}
+
+func RunByIdAndScript(id, script string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunByIdAndScript(id, script, values...)
+}
+
+func RunById(id string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunById(id, values...)
+}
+
+func RunFunction(id, name string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunFunction(id, name, values...)
+}
+
+func RunFunctionWithContext(ctx context.Context, id, name string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunFunctionWithContext(ctx, id, name, values...)
+}
+
+func RunWithContext(ctx context.Context, script string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunWithContext(ctx, script, values...)
+}
+
+func RunByIdAndScriptWithContext(ctx context.Context, id, script string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunByIdAndScriptWithContext(ctx, id, script, values...)
+}
+
+func RunByIdWithContext(ctx context.Context, id string, values ...interface{}) (goja.Value, error) {
+	return defaultEngine.RunByIdWithContext(ctx, id, values...)
+}
+
+func RunBatch(id, script string, args [][]interface{}) ([]BatchResult, error) {
+	return defaultEngine.RunBatch(id, script, args)
+}
+
+func RunBatchWithContext(ctx context.Context, id, script string, args [][]interface{}) ([]BatchResult, error) {
+	return defaultEngine.RunBatchWithContext(ctx, id, script, args)
+}
+
+func Preload(id, script string) error {
+	return defaultEngine.Preload(id, script)
+}
+
+func Invalidate(id string) bool {
+	return defaultEngine.Invalidate(id)
+}
+
+func List() []ScriptInfo {
+	return defaultEngine.List()
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return defaultEngine.NewPipeline(stages...)
+}
+
+func Validate(script string) *ValidationResult {
+	return defaultEngine.Validate(script)
+}
+
+func StartCPUProfile(ids ...string) (*CPUProfile, error) {
+	return defaultEngine.StartCPUProfile(ids...)
+}
+
+func ProfileCPU(ctx context.Context, duration time.Duration, w io.Writer, ids ...string) error {
+	return defaultEngine.ProfileCPU(ctx, duration, w, ids...)
 }
 
 func BufferToBytes(bufferVal goja.Value) ([]byte, error) {
@@ -279,3 +442,15 @@ func BytesToBuffer(vm *goja.Runtime, bs []byte) (goja.Value, error) {
 	})
 	return buf, nil
 }
+
+// vmBaseMemory 一个 VM 加载内置库后占用内存的估算值
+const vmBaseMemory = 64 << 10
+
+// memoryEstimate 估算脚本 VM 池占用的内存, 每个 VM 按基础占用加脚本长度的 16 倍估算, 用于缓存的内存上限
+func (j *JSvm) memoryEstimate() int64 {
+	size := 1
+	if j.pool != nil {
+		size = j.pool.size
+	}
+	return int64(size) * (vmBaseMemory + 16*int64(len(j.Script)))
+}